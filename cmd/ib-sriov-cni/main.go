@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -8,18 +9,29 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/containernetworking/cni/pkg/invoke"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	cniVersion "github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/gofrs/flock"
 	"github.com/vishvananda/netlink"
 
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/cdi"
 	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/devinfo"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/guidpool"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/ipoib"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/journal"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/logging"
 	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/sriov"
 	localtypes "github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
@@ -29,6 +41,16 @@ const (
 	infiniBandAnnotation = "mellanox.infiniband.app"
 	configuredInfiniBand = "configured"
 	ipamDHCP             = "dhcp"
+	uncachedVFNamePrefix = "vfdev"
+	vfReturnTimeout      = 10 * time.Second
+	pkeyCheckTimeout     = 10 * time.Second
+)
+
+// CNI STATUS error codes
+// see https://github.com/containernetworking/cni/blob/main/SPEC.md#status-check-plugin-status
+const (
+	errPluginNotAvailable  uint = 50
+	errLimitedConnectivity uint = 51
 )
 
 var (
@@ -45,36 +67,98 @@ func init() {
 	runtime.LockOSThread()
 }
 
-func getGUIDFromConf(netConf *localtypes.NetConf) string {
+func getGUIDFromConf(netConf *localtypes.NetConf, args *skel.CmdArgs) (string, error) {
 	// Take from runtime config if available
 	if netConf.RuntimeConfig.InfinibandGUID != "" {
-		return netConf.RuntimeConfig.InfinibandGUID
+		return netConf.RuntimeConfig.InfinibandGUID, nil
 	}
 	// Take from CNI_ARGS if available
 	if guid, ok := netConf.Args.CNI["guid"]; ok {
-		return guid
+		return guid, nil
+	}
+	// Derive from the pod identity if configured
+	if netConf.GUIDMode == config.GUIDModeDerived {
+		return config.DeriveGUID(netConf, args)
 	}
 
 	// No guid provided
-	return ""
+	return "", nil
+}
+
+// newManager returns the manager handling the device of the attachment
+func newManager(netConf *localtypes.NetConf) localtypes.Manager {
+	if netConf.PFChildMode {
+		return ipoib.NewIPoIBManager()
+	}
+	return sriov.NewSriovManager()
+}
+
+// hasPodNetdev returns true if the plugin configures the device of the attachment, i.e. a VF or
+// an IPoIB child of the PF. PF passthrough devices are not configured by the plugin.
+func hasPodNetdev(netConf *localtypes.NetConf) (bool, error) {
+	if netConf.PFChildMode {
+		return true, nil
+	}
+	isVF, err := utils.IsVirtualFunction(netConf.DeviceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to determine if device %s is VF or PF: %v", netConf.DeviceID, err)
+	}
+	return isVF, nil
+}
+
+// cniLock serializes CNI operations on the devices covered by the configured lockScope
+type cniLock struct {
+	scoped  *flock.Flock
+	timeout time.Duration
+}
+
+func lockCNIExecution(netConf *localtypes.NetConf) (*cniLock, error) {
+	timeout := config.GetLockTimeout(netConf)
+	lock, err := acquireFileLock(config.GetLockFilePath(netConf), timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &cniLock{scoped: lock, timeout: timeout}, nil
 }
 
-func lockCNIExecution() (*flock.Flock, error) {
+func unlockCNIExecution(lock *cniLock) {
+	releaseFileLock(lock.scoped)
+}
+
+// lockRdmaNaming serializes operations causing the kernel to (re)create RDMA devices of a VF
+// and returns a function releasing the lock.
+func (l *cniLock) lockRdmaNaming() (func(), error) {
 	// Note: Unbind/Bind VF and move RDMA device to namespace causes rdma resources to be re-created for the VF.
 	// CNI may be invoked in parallel and kernel may provide the VF's RDMA resources under a different name.
 	// As the mapping of RDMA resources is done in Device plugin prior to CNI invocation, it must not change here.
-	// We serialize the CNI's operation causing kernel to allocate the VF's RDMA resources under the same name.
+	// We serialize these operations node wide causing kernel to allocate the VF's RDMA resources under the same name,
+	// regardless of the lockScope used for the rest of the CNI operation.
 	// In the future, Systems should use udev PCI based RDMA device names, ensuring consistent RDMA resources names.
-	err := os.MkdirAll(config.CniFileLockDir, utils.OwnerReadWriteExecuteAttrs)
+	globalLockFile := config.GetGlobalLockFilePath()
+	if l.scoped.Path() == globalLockFile {
+		// already serialized node wide
+		return func() {}, nil
+	}
+
+	lock, err := acquireFileLock(globalLockFile, l.timeout)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create ib-sriov-cni lock file directory(%q): %v", config.CniFileLockDir, err)
+		return nil, err
 	}
+	return func() { releaseFileLock(lock) }, nil
+}
 
-	lock := flock.New(filepath.Join(config.CniFileLockDir, config.CniFileLockName))
-	err = lock.Lock()
+// acquireFileLock takes the file lock, failing with a CNI try again later error if it is not acquired within timeout
+func acquireFileLock(lockFile string, timeout time.Duration) (*flock.Flock, error) {
+	lock, err := utils.LockFile(lockFile, timeout)
+	if errors.Is(err, utils.ErrLockTimeout) {
+		return nil, types.NewError(types.ErrTryAgainLater,
+			fmt.Sprintf("timed out after %s waiting for lock %s", timeout, lockFile), "")
+	}
 	if err != nil {
 		return nil, err
 	}
+	logging.Debug("acquired CNI lock", "lockFile", lockFile)
+
 	// unlock on signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -91,8 +175,16 @@ func lockCNIExecution() (*flock.Flock, error) {
 	return lock, nil
 }
 
-func unlockCNIExecution(lock *flock.Flock) {
-	_ = lock.Unlock()
+func releaseFileLock(lock *flock.Flock) {
+	if err := lock.Unlock(); err != nil {
+		logging.Error("failed to unlock CNI execution", "lockFile", lock.Path(), "error", err)
+	}
+}
+
+// initLogging configures logging from netConf and adds the attachment identity to every log line
+func initLogging(netConf *localtypes.NetConf, args *skel.CmdArgs) {
+	logging.Init(netConf.LogLevel, netConf.LogFile)
+	logging.AddFields("containerID", args.ContainerID, "ifname", args.IfName, "deviceID", netConf.DeviceID)
 }
 
 func handleVfioPciDetection(netConf *localtypes.NetConf) error {
@@ -112,52 +204,48 @@ func handleVfioPciDetection(netConf *localtypes.NetConf) error {
 			netConf.VfioPciMode = true
 		}
 	}
-	return nil
-}
-
-// Get network config, updated with GUID, device info and network namespace.
-func getNetConfNetns(args *skel.CmdArgs) (*localtypes.NetConf, ns.NetNS, error) {
-	netConf, err := config.LoadConf(args.StdinData)
-	if err != nil {
-		return nil, nil, fmt.Errorf("infiniBand SRI-OV CNI failed to load netconf: %v", err)
-	}
 
-	if netConf.IBKubernetesEnabled && netConf.Args.CNI[infiniBandAnnotation] != configuredInfiniBand {
-		return nil, nil, fmt.Errorf(
-			"infiniBand SRIOV-CNI failed, InfiniBand status \"%s\" is not \"%s\" please check mellanox ib-kubernetes",
-			infiniBandAnnotation, configuredInfiniBand)
+	// vfio-pci devices have no netdevice to create the IPoIB child on
+	if netConf.VfioPciMode && netConf.IPoIBChildPKey {
+		return fmt.Errorf("ipoibChildPKey is not supported for vfio-pci device %s", netConf.DeviceID)
 	}
+	return nil
+}
 
+// loadVFInfo updates the network config with the VF device information and GUID
+func loadVFInfo(netConf *localtypes.NetConf, args *skel.CmdArgs) error {
 	if netConf.RdmaIsolation {
-		err = utils.EnsureRdmaSystemMode()
-		if err != nil {
-			return nil, nil, err
+		if err := utils.EnsureRdmaSystemMode(); err != nil {
+			return err
 		}
 	}
 
 	// Validate deviceID is provided
 	if netConf.DeviceID == "" {
-		return nil, nil, fmt.Errorf("deviceID is required")
+		return fmt.Errorf("deviceID is required")
 	}
 
 	// Handle vfio-pci detection
 	if err := handleVfioPciDetection(netConf); err != nil {
-		return nil, nil, err
+		return err
 	}
 
 	// Check if device is PF or VF to load appropriate device info
 	isVF, err := utils.IsVirtualFunction(netConf.DeviceID)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to determine if device %s is VF or PF: %v", netConf.DeviceID, err)
+		return fmt.Errorf("failed to determine if device %s is VF or PF: %v", netConf.DeviceID, err)
 	}
 	netConf.IsVFDevice = isVF
 
-	netConf.GUID = getGUIDFromConf(netConf)
+	netConf.GUID, err = getGUIDFromConf(netConf, args)
+	if err != nil {
+		return err
+	}
 
 	// Ensure GUID was provided if ib-kubernetes integration is enabled
 	// Note: PF devices already have their own GUID, so only check for VF devices
 	if netConf.IBKubernetesEnabled && netConf.IsVFDevice && netConf.GUID == "" {
-		return nil, nil, fmt.Errorf(
+		return fmt.Errorf(
 			"infiniband SRIOV-CNI failed, Unexpected error. GUID must be provided by ib-kubernetes")
 	}
 
@@ -165,8 +253,47 @@ func getNetConfNetns(args *skel.CmdArgs) (*localtypes.NetConf, ns.NetNS, error)
 	if netConf.IsVFDevice {
 		err = config.LoadDeviceInfo(netConf)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to get VF device information: %v", err)
+			return fmt.Errorf("failed to get VF device information: %v", err)
 		}
+		logging.AddFields("vf", netConf.VFID)
+	}
+	return nil
+}
+
+// loadPFChildInfo updates the network config with the information of the PF the IPoIB child is created on
+func loadPFChildInfo(netConf *localtypes.NetConf, args *skel.CmdArgs) error {
+	// the IPoIB child shares the PF GUID
+	guid, err := getGUIDFromConf(netConf, args)
+	if err != nil {
+		return err
+	}
+	if guid != "" || netConf.RuntimeConfig.NodeGUID != "" || netConf.RuntimeConfig.PortGUID != "" {
+		return fmt.Errorf("guid is not supported in pfChildMode, the IPoIB child uses the PF GUID")
+	}
+	return config.LoadPFInfo(netConf)
+}
+
+// Get network config, updated with GUID, device info and network namespace.
+func getNetConfNetns(args *skel.CmdArgs) (*localtypes.NetConf, ns.NetNS, error) {
+	netConf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("infiniBand SRI-OV CNI failed to load netconf: %v", err)
+	}
+	initLogging(netConf, args)
+
+	if netConf.IBKubernetesEnabled && netConf.Args.CNI[infiniBandAnnotation] != configuredInfiniBand {
+		return nil, nil, fmt.Errorf(
+			"infiniBand SRIOV-CNI failed, InfiniBand status \"%s\" is not \"%s\" please check mellanox ib-kubernetes",
+			infiniBandAnnotation, configuredInfiniBand)
+	}
+
+	if netConf.PFChildMode {
+		err = loadPFChildInfo(netConf, args)
+	} else {
+		err = loadVFInfo(netConf, args)
+	}
+	if err != nil {
+		return nil, nil, err
 	}
 
 	netns, err := ns.GetNS(args.Netns)
@@ -176,41 +303,302 @@ func getNetConfNetns(args *skel.CmdArgs) (*localtypes.NetConf, ns.NetNS, error)
 	return netConf, netns, nil
 }
 
-// Applies VF config and performs VF setup. if RdmaIsolation is configured, moves RDMA device into namespace
-func doVFConfig(sm localtypes.Manager, netConf *localtypes.NetConf, netns ns.NetNS, args *skel.CmdArgs) (retErr error) {
+// releaseVF returns the VF to the default namespace while rolling back a failed ADD
+func releaseVF(sm localtypes.Manager, netConf *localtypes.NetConf, args *skel.CmdArgs, netns ns.NetNS) {
+	if err := sm.ReleaseVF(netConf, args.IfName, args.ContainerID, netns); err != nil {
+		logging.Error("failed to release VF while rolling back", "error", err)
+	}
+}
+
+// restoreRdmaDev returns the RDMA device to the default namespace while rolling back a failed ADD. It does
+// nothing if the RDMA device is not in the pod namespace, e.g. when it was not moved.
+func restoreRdmaDev(netConf *localtypes.NetConf, netns ns.NetNS) {
+	if utils.CheckRdmaDevInNs(netConf.RdmaNetState.ContainerRdmaDevName, netns) != nil {
+		return
+	}
+	if err := utils.MoveRdmaDevFromNs(netConf.RdmaNetState.ContainerRdmaDevName, netns); err != nil {
+		logging.Error("failed to restore RDMA device to default namespace while rolling back",
+			"rdmaDev", netConf.RdmaNetState.ContainerRdmaDevName, "error", err)
+	}
+}
+
+// resetVFConfig resets the VF configuration and drops the journal while rolling back a failed ADD
+func resetVFConfig(sm localtypes.Manager, netConf *localtypes.NetConf, j *journal.Journal, lock *cniLock) {
+	if j.Has(journal.StepVFConfig) {
+		unlockRdmaNaming, err := lock.lockRdmaNaming()
+		if err != nil {
+			logging.Error("failed to reset VF config while rolling back, keeping journal", "error", err)
+			return
+		}
+		defer unlockRdmaNaming()
+		if j.Has(journal.StepNodeDesc) {
+			if err = restoreVFNodeDesc(netConf); err != nil {
+				logging.Error("failed to restore node description while rolling back", "error", err)
+			}
+		}
+		if err = sm.ResetVFConfig(netConf); err != nil {
+			logging.Error("failed to reset VF config while rolling back, keeping journal", "error", err)
+			return
+		}
+	}
+	if j.Has(journal.StepGUIDPool) {
+		if err := releasePoolGUID(netConf, j.ContainerID, j.IfName); err != nil {
+			logging.Error("failed to release GUID while rolling back, keeping journal", "error", err)
+			return
+		}
+	}
+	removeJournal(j)
+}
+
+// allocatePoolGUID gives the VF a GUID of the guidPool if no GUID is provided by the runtime config or CNI_ARGS
+func allocatePoolGUID(netConf *localtypes.NetConf, args *skel.CmdArgs, j *journal.Journal) error {
+	// the pool GUID is not used if both the node and the port GUID are provided
+	if (netConf.GetNodeGUID() != "" && netConf.GetPortGUID() != "") || len(netConf.GUIDPool) == 0 || !netConf.IsVFDevice {
+		return nil
+	}
+
+	pool, err := guidpool.New(config.GetGUIDPoolDir(), netConf.GUIDPool, config.GetLockTimeout(netConf))
+	if err != nil {
+		return err
+	}
+	// recorded before allocating, releasing a GUID which was not allocated does nothing
+	if err = j.Record(journal.StepGUIDPool); err != nil {
+		return err
+	}
+	guid, err := pool.Allocate(config.GetAttachmentID(args.ContainerID, args.IfName), netConf.Name)
+	if err != nil {
+		return err
+	}
+	netConf.GUID = guid
+	logging.Debug("allocated GUID from guidPool", "guid", guid)
+	return nil
+}
+
+// releasePoolGUID returns the GUID allocated to the attachment to the guidPool
+func releasePoolGUID(netConf *localtypes.NetConf, containerID, ifName string) error {
+	return guidpool.Release(config.GetGUIDPoolDir(), config.GetAttachmentID(containerID, ifName), config.GetLockTimeout(netConf))
+}
+
+// removeJournal removes the journal once the attachment is cached or rolled back
+func removeJournal(j *journal.Journal) {
+	if err := j.Remove(); err != nil {
+		logging.Error("failed to remove journal", "error", err)
+	}
+}
+
+// releaseIPAM releases the IPAM allocation while rolling back a failed ADD
+func releaseIPAM(netConf *localtypes.NetConf, stdinData []byte) {
+	if err := ipam.ExecDel(netConf.IPAM.Type, stdinData); err != nil {
+		logging.Error("failed to release IPAM allocation while rolling back", "ipam", netConf.IPAM.Type, "error", err)
+	}
+}
+
+// applyVFConfig applies VF config. It must be called while holding the RDMA naming lock.
+func applyVFConfig(sm localtypes.Manager, netConf *localtypes.NetConf, j *journal.Journal) error {
+	// recorded before applying so that the VF is reset if the plugin is interrupted, e.g. after the GUID
+	// is programmed, and recorded again once applied to save the original GUIDs of the VF
+	if err := j.Record(journal.StepVFConfig); err != nil {
+		return err
+	}
 	err := sm.ApplyVFConfig(netConf)
 	if err != nil {
+		// the GUID may have been programmed before the failure, e.g. when it doesn't read back as requested
+		if recErr := j.Record(journal.StepVFConfig); recErr != nil {
+			logging.Error("failed to record VF config step", "error", recErr)
+		}
 		return fmt.Errorf("infiniBand SRI-OV CNI failed to configure VF %q", err)
 	}
+	return j.Record(journal.StepVFConfig)
+}
 
-	// VFIO devices don't have network interfaces, skip SetupVF
-	if netConf.VfioPciMode {
-		return nil
+// prepareVFRdmaDev waits for the port of the VF RDMA device to be ACTIVE, checks the pkey and sets the node
+// description, all through sysfs of the default namespace
+func prepareVFRdmaDev(netConf *localtypes.NetConf, args *skel.CmdArgs, j *journal.Journal) error {
+	if netConf.WaitForPortActive > 0 {
+		if err := waitForVFPortActive(netConf); err != nil {
+			return err
+		}
+	}
+
+	if netConf.PKey != "" {
+		if err := checkVFPKey(netConf); err != nil {
+			return err
+		}
+	}
+
+	// node description is reset by the rebind
+	if netConf.NodeDescription != "" {
+		if err := setVFNodeDesc(netConf, args, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForVFPortActive waits for the subnet manager to bring the port of the VF RDMA device to ACTIVE,
+// the error asks the runtime to retry ADD on timeout
+func waitForVFPortActive(netConf *localtypes.NetConf) error {
+	timeout := time.Duration(netConf.WaitForPortActive) * time.Second
+	msg := fmt.Sprintf("VF %s port is not active", netConf.DeviceID)
+	rdmaDev, err := utils.WaitForPciRdmaDev(netConf.DeviceID, timeout)
+	if err != nil {
+		return types.NewError(types.ErrTryAgainLater, msg, err.Error())
+	}
+	if err = utils.WaitForRdmaDevPortsActive(rdmaDev, netConf.WaitForPhysLinkUp, timeout); err != nil {
+		return types.NewError(types.ErrTryAgainLater, msg, err.Error())
 	}
+	logging.Debug("VF port is active", "rdmaDev", rdmaDev)
+	return nil
+}
 
+// moveVFRdmaDev moves the VF RDMA device into namespace. It must be called while holding the RDMA naming lock.
+func moveVFRdmaDev(netConf *localtypes.NetConf, netns ns.NetNS, j *journal.Journal) error {
 	// Note(adrianc): We do this here as ApplyVFCOnfig is rebinding the VF, causing the RDMA device to be recreated.
 	// We do this here due to some un-intuitive kernel behavior (which i hope will change), moving an RDMA device
 	// to namespace causes all of its associated ULP devices (IPoIB) to be recreated in the default namespace,
 	// hence SetupVF needs to occur after moving RDMA device to namespace
+	rdmaDev, err := utils.GetRdmaDevForPciDev(netConf.DeviceID)
+	if err != nil {
+		return err
+	}
+	// Save RDMA state
+	netConf.RdmaNetState.DeviceID = netConf.DeviceID
+	netConf.RdmaNetState.SandboxRdmaDevName = rdmaDev
+	netConf.RdmaNetState.ContainerRdmaDevName = rdmaDev
+	// recorded before moving, the RDMA device is restored only if it is found in the pod namespace
+	if err = j.Record(journal.StepRdmaMoved); err != nil {
+		return err
+	}
+	if err = utils.MoveRdmaDevToNs(rdmaDev, netns); err != nil {
+		restoreRdmaDev(netConf, netns)
+		return err
+	}
+	logging.Debug("moved RDMA device to pod namespace", "rdmaDev", rdmaDev)
+	return nil
+}
+
+// setVFNodeDesc sets the node description of the VF RDMA device, saving the original one to restore it on DEL
+func setVFNodeDesc(netConf *localtypes.NetConf, args *skel.CmdArgs, j *journal.Journal) error {
+	desc, err := config.RenderNodeDescription(netConf, args)
+	if err != nil {
+		return err
+	}
+	origDesc, err := utils.GetPciRdmaDevNodeDesc(netConf.DeviceID)
+	if err != nil {
+		return err
+	}
+	netConf.HostNodeDesc = origDesc
+	// recorded with the original node description before setting it
+	if err = j.Record(journal.StepNodeDesc); err != nil {
+		return err
+	}
+	if err = utils.SetPciRdmaDevNodeDesc(netConf.DeviceID, desc); err != nil {
+		return err
+	}
+	logging.Debug("set VF node description", "nodeDesc", desc)
+	return nil
+}
+
+// restoreVFNodeDesc restores the original node description of the VF RDMA device
+func restoreVFNodeDesc(netConf *localtypes.NetConf) error {
+	if netConf.HostNodeDesc == "" {
+		return nil
+	}
+	if err := utils.SetPciRdmaDevNodeDesc(netConf.DeviceID, netConf.HostNodeDesc); err != nil {
+		return fmt.Errorf("failed to restore node description of VF %s: %v", netConf.DeviceID, err)
+	}
+	return nil
+}
+
+// checkVFPKey verifies that the partition of the configured pkey is in the pkey table of the VF, or of the PF
+// the IPoIB child is created on
+func checkVFPKey(netConf *localtypes.NetConf) error {
+	pkey, err := utils.ParsePKey(netConf.PKey)
+	if err != nil {
+		return err
+	}
+	device := "VF"
+	if netConf.PFChildMode {
+		device = "PF"
+	}
+
+	// the subnet manager programs the pkey table after the rebind, once the port is ACTIVE
+	timeout := pkeyCheckTimeout
+	if netConf.WaitForPortActive > 0 {
+		timeout = time.Duration(netConf.WaitForPortActive) * time.Second
+	}
+	rdmaDev, err := utils.WaitForPciRdmaDev(netConf.DeviceID, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to check pkey %s, RDMA device of %s %s not found: %v", netConf.PKey, device,
+			netConf.DeviceID, err)
+	}
+
+	if err = utils.WaitForRdmaDevPKey(rdmaDev, pkey, timeout); err != nil {
+		return fmt.Errorf("pkey %s is not in the pkey table of %s %s (RDMA device %s), "+
+			"check the partition membership of the %s GUID in the subnet manager: %v", netConf.PKey, device, netConf.DeviceID,
+			rdmaDev, device, err)
+	}
+	logging.Debug("device is a member of the partition", "pkey", netConf.PKey, "rdmaDev", rdmaDev)
+	return nil
+}
+
+// Applies VF config and performs VF setup. if RdmaIsolation is configured, moves RDMA device into namespace.
+// VF rebind and RDMA device moves hold the RDMA naming lock. Returns the identity of the RDMA device, if known.
+func doVFConfig(sm localtypes.Manager, netConf *localtypes.NetConf, netns ns.NetNS, args *skel.CmdArgs,
+	lock *cniLock, j *journal.Journal) (_ *utils.RdmaDevInfo, retErr error) {
+	unlockRdmaNaming, err := lock.lockRdmaNaming()
+	if err != nil {
+		return nil, err
+	}
+	err = applyVFConfig(sm, netConf, j)
+	unlockRdmaNaming()
+	if err != nil {
+		return nil, err
+	}
+
+	// VFIO devices don't have network interfaces nor RDMA devices, skip SetupVF
+	if netConf.VfioPciMode {
+		return nil, nil
+	}
+
+	// the RDMA naming lock is not held while waiting for the port to become ACTIVE. The lockScope lock is, which
+	// is why waitForPortActive requires the pf or vf lockScope.
+	if err = prepareVFRdmaDev(netConf, args, j); err != nil {
+		return nil, err
+	}
+
+	// read before the RDMA device is moved out of sight of the default namespace sysfs. The RDMA device of a PF
+	// is not the pod's, it is not reported for an IPoIB child of the PF.
+	var rdmaInfo *utils.RdmaDevInfo
+	if !netConf.PFChildMode {
+		rdmaInfo, err = utils.GetPciRdmaDevInfo(netConf.DeviceID)
+		if err != nil {
+			logging.Warning("failed to get RDMA device identity", "deviceID", netConf.DeviceID, "error", err)
+		}
+	}
+
 	if netConf.RdmaIsolation {
-		var rdmaDev string
-		rdmaDev, err = utils.MoveRdmaDevToNsPci(netConf.DeviceID, netns)
+		unlockRdmaNaming, err = lock.lockRdmaNaming()
 		if err != nil {
-			return err
+			return nil, err
+		}
+		err = moveVFRdmaDev(netConf, netns, j)
+		unlockRdmaNaming()
+		if err != nil {
+			return nil, err
 		}
-		// Save RDMA state
-		netConf.RdmaNetState.DeviceID = netConf.DeviceID
-		netConf.RdmaNetState.SandboxRdmaDevName = rdmaDev
-		netConf.RdmaNetState.ContainerRdmaDevName = rdmaDev
 		// restore RDMA device back to default namespace in case of error
-		// Note(adrianc): as there is no logging, we have little visibility if the restore operation failed.
 		defer func() {
 			if retErr != nil {
-				_ = utils.MoveRdmaDevFromNs(netConf.RdmaNetState.ContainerRdmaDevName, netns)
+				restoreRdmaDev(netConf, netns)
 			}
 		}()
 	}
 
+	// recorded before the setup, the VF is released only if the pod interface is found in the pod namespace
+	if err = j.Record(journal.StepVFSetup); err != nil {
+		return nil, err
+	}
 	err = sm.SetupVF(netConf, args.IfName, args.ContainerID, netns)
 	if err != nil {
 		nsErr := netns.Do(func(_ ns.NetNS) error {
@@ -218,12 +606,12 @@ func doVFConfig(sm localtypes.Manager, netConf *localtypes.NetConf, netns ns.Net
 			return innerErr
 		})
 		if nsErr == nil {
-			_ = sm.ReleaseVF(netConf, args.IfName, args.ContainerID, netns)
+			releaseVF(sm, netConf, args, netns)
 		}
-		return fmt.Errorf("failed to set up pod interface %q from the device %q: %v",
+		return nil, fmt.Errorf("failed to set up pod interface %q from the device %q: %v",
 			args.IfName, netConf.DeviceID, err)
 	}
-	return nil
+	return rdmaInfo, nil
 }
 
 // Run the IPAM plugin
@@ -239,7 +627,7 @@ func runIPAMPlugin(stdinData []byte, netConf *localtypes.NetConf) (_ *current.Re
 
 	defer func() {
 		if retErr != nil {
-			_ = ipam.ExecDel(netConf.IPAM.Type, stdinData)
+			releaseIPAM(netConf, stdinData)
 		}
 	}()
 
@@ -262,10 +650,24 @@ func runIPAMPlugin(stdinData []byte, netConf *localtypes.NetConf) (_ *current.Re
 }
 
 // handleVFAdd handles VF device configuration in cmdAdd
-func handleVFAdd(args *skel.CmdArgs, netConf *localtypes.NetConf, netns ns.NetNS, result *current.Result) (retErr error) {
-	sm := sriov.NewSriovManager()
+func handleVFAdd(args *skel.CmdArgs, netConf *localtypes.NetConf, netns ns.NetNS, result *current.Result,
+	lock *cniLock) (retErr error) {
+	sm := newManager(netConf)
+
+	// Every step is recorded before it is started so it can be undone by DEL if the plugin is interrupted
+	// before the NetConf is cached, undoing a step which had no effect does nothing
+	j := journal.New(config.GetJournalPath(args.ContainerID, args.IfName), args.ContainerID, args.IfName, netConf)
+	defer func() {
+		if retErr != nil {
+			resetVFConfig(sm, netConf, j, lock)
+		}
+	}()
+
+	if err := allocatePoolGUID(netConf, args, j); err != nil {
+		return err
+	}
 
-	err := doVFConfig(sm, netConf, netns, args)
+	rdmaInfo, err := doVFConfig(sm, netConf, netns, args, lock, j)
 	if err != nil {
 		return err
 	}
@@ -276,16 +678,28 @@ func handleVFAdd(args *skel.CmdArgs, netConf *localtypes.NetConf, netns ns.NetNS
 				return innerErr
 			})
 			if nsErr == nil {
-				_ = sm.ReleaseVF(netConf, args.IfName, args.ContainerID, netns)
+				releaseVF(sm, netConf, args, netns)
 			}
 			if netConf.RdmaIsolation {
-				_ = utils.MoveRdmaDevFromNs(netConf.RdmaNetState.ContainerRdmaDevName, netns)
+				restoreRdmaDev(netConf, netns)
 			}
 		}
 	}()
 
+	result.Interfaces[0].PciID = netConf.DeviceID
+	// VFIO devices don't have network interfaces
+	if !netConf.VfioPciMode {
+		if err = setPodIfAttrs(result.Interfaces[0], netns); err != nil {
+			return err
+		}
+	}
+
 	// VFIO devices don't have network interfaces, skip IPAM configuration
 	if netConf.IPAM.Type != "" && !netConf.VfioPciMode {
+		// IPAM DEL of an address which was not allocated does nothing
+		if err = j.Record(journal.StepIPAM); err != nil {
+			return err
+		}
 		var newResult *current.Result
 		newResult, err = runIPAMPlugin(args.StdinData, netConf)
 		if err != nil {
@@ -294,7 +708,7 @@ func handleVFAdd(args *skel.CmdArgs, netConf *localtypes.NetConf, netns ns.NetNS
 		// If runIPAMPlugin failed, than ExecDel was called. Defer if no error
 		defer func() {
 			if retErr != nil {
-				_ = ipam.ExecDel(netConf.IPAM.Type, args.StdinData)
+				releaseIPAM(netConf, args.StdinData)
 			}
 		}()
 
@@ -316,52 +730,216 @@ func handleVFAdd(args *skel.CmdArgs, netConf *localtypes.NetConf, netns ns.NetNS
 		*result = *newResult
 	}
 
+	if err = saveAttachmentFiles(netConf, args, rdmaInfo); err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			cleanAttachmentFiles(netConf, args)
+		}
+	}()
+
 	// Cache NetConf for CmdDel
 	if err = utils.SaveNetConf(args.ContainerID, config.DefaultCNIDir, args.IfName, netConf); err != nil {
 		return fmt.Errorf("error saving NetConf: %v", err)
 	}
+	removeJournal(j)
 
 	return nil
 }
 
-func cmdAdd(args *skel.CmdArgs) (retErr error) {
-	netConf, netns, err := getNetConfNetns(args)
+// setPodIfAttrs sets the mtu and the 20 bytes IPoIB hardware address of the pod interface in the result
+func setPodIfAttrs(iface *current.Interface, netns ns.NetNS) error {
+	err := netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(iface.Name)
+		if err != nil {
+			return err
+		}
+		iface.Mtu = link.Attrs().MTU
+		iface.Mac = link.Attrs().HardwareAddr.String()
+		return nil
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to get attributes of pod interface %s: %v", iface.Name, err)
 	}
-	defer func() { _ = netns.Close() }()
+	return nil
+}
 
-	// Lock CNI operation to serialize the operation
-	lock, err := lockCNIExecution()
-	if err != nil {
-		return err
+// newDeviceInfo returns the device-info of the attachment with the RDMA details of the device, if known
+func newDeviceInfo(netConf *localtypes.NetConf, rdmaInfo *utils.RdmaDevInfo) *devinfo.DeviceInfo {
+	pci := &devinfo.PciDevice{PciAddress: netConf.DeviceID}
+	if netConf.IsVFDevice && netConf.Master != "" {
+		if pfPci, err := utils.GetNetdevPciAddress(netConf.Master); err == nil {
+			pci.PfPciAddress = pfPci
+		}
 	}
-	defer unlockCNIExecution(lock)
+	info := devinfo.NewPciDeviceInfo(pci)
+	if rdmaInfo != nil {
+		pci.RdmaDevice = rdmaInfo.Name
+		info.Metadata = map[string]string{
+			devinfo.MetadataRdmaUverbs:   rdmaInfo.Uverbs,
+			devinfo.MetadataRdmaPortGUID: rdmaInfo.PortGUID,
+			devinfo.MetadataRdmaLID:      rdmaInfo.LID,
+		}
+	}
+	return info
+}
 
-	result := &current.Result{}
-	result.Interfaces = []*current.Interface{{
-		Name:    args.IfName,
-		Sandbox: netns.Path(),
+// saveAttachmentFiles writes the CDI spec of the RDMA char devices, if configured, and the device-info file
+// of the attachment
+func saveAttachmentFiles(netConf *localtypes.NetConf, args *skel.CmdArgs, rdmaInfo *utils.RdmaDevInfo) error {
+	info := newDeviceInfo(netConf, rdmaInfo)
+	if netConf.CDISpec {
+		cdiDevice, err := saveCDISpec(netConf, args, rdmaInfo)
+		if err != nil {
+			return err
+		}
+		info.Metadata[devinfo.MetadataCDIDevice] = cdiDevice
+	}
+
+	netConf.DevInfoPath = devinfo.GetPath(netConf.Name, args.ContainerID, args.IfName)
+	if err := devinfo.Save(netConf.Name, args.ContainerID, args.IfName, info); err != nil {
+		cleanCDISpec(args)
+		return err
+	}
+	return nil
+}
+
+// cleanAttachmentFiles removes the device-info file and the CDI spec of the attachment
+func cleanAttachmentFiles(netConf *localtypes.NetConf, args *skel.CmdArgs) {
+	cleanDeviceInfo(netConf, args)
+	cleanCDISpec(args)
+}
+
+// saveCDISpec writes the CDI spec of the RDMA char devices of the VF and returns the qualified device name
+func saveCDISpec(netConf *localtypes.NetConf, args *skel.CmdArgs, rdmaInfo *utils.RdmaDevInfo) (string, error) {
+	if rdmaInfo == nil {
+		return "", fmt.Errorf("failed to generate CDI spec, RDMA char devices of %s not found", netConf.DeviceID)
+	}
+	deviceName, err := config.GetCDIDeviceName(args)
+	if err != nil {
+		return "", err
+	}
+
+	charDevs := []string{rdmaInfo.Uverbs}
+	if rdmaInfo.Umad != "" {
+		charDevs = append(charDevs, rdmaInfo.Umad)
+	}
+	// rdma_cm exists only if the rdma_ucm module is loaded, the runtime fails to create the container otherwise
+	if _, err = os.Stat(utils.RdmaCmCharDev); err == nil {
+		charDevs = append(charDevs, utils.RdmaCmCharDev)
+	}
+
+	netConf.CDISpecPath = cdi.GetSpecPath(args.ContainerID, args.IfName)
+	if err = cdi.Save(netConf.CDISpecPath, cdi.NewSpec(deviceName, charDevs)); err != nil {
+		return "", err
+	}
+	logging.Debug("saved CDI spec", "device", cdi.QualifiedName(deviceName), "charDevs", charDevs)
+	return cdi.QualifiedName(deviceName), nil
+}
+
+// cleanDeviceInfo removes the device-info file of the attachment
+func cleanDeviceInfo(netConf *localtypes.NetConf, args *skel.CmdArgs) {
+	if err := devinfo.Clean(netConf.Name, args.ContainerID, args.IfName); err != nil {
+		logging.Error("failed to remove device-info file", "error", err)
+	}
+}
+
+// cleanCDISpec removes the CDI spec of the attachment
+func cleanCDISpec(args *skel.CmdArgs) {
+	if err := cdi.Remove(cdi.GetSpecPath(args.ContainerID, args.IfName)); err != nil {
+		logging.Error("failed to remove CDI spec", "error", err)
+	}
+}
+
+// handleRepeatedAdd prints the cached result of an attachment which was already added with the same configuration.
+// It returns false if the attachment is not cached.
+func handleRepeatedAdd(args *skel.CmdArgs) (bool, error) {
+	cachedConf, _, err := config.LoadConfFromCache(args)
+	if err != nil {
+		return false, nil
+	}
+
+	netConf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return true, fmt.Errorf("infiniBand SRI-OV CNI failed to load netconf: %v", err)
+	}
+	initLogging(netConf, args)
+
+	if config.IsCachedByOlderVersion(cachedConf) {
+		logging.Error("attachment already added by an older version of the plugin")
+		return true, fmt.Errorf("interface %q of container %q was already added by an older version of the plugin, "+
+			"which did not cache its result, it must be deleted first", args.IfName, args.ContainerID)
+	}
+	if cachedConf.ConfigHash != config.GetConfigHash(args) || cachedConf.Result == nil {
+		logging.Error("attachment already exists with a different configuration")
+		return true, fmt.Errorf("interface %q of container %q was already added with a different configuration, "+
+			"it must be deleted first", args.IfName, args.ContainerID)
+	}
+
+	logging.Info("attachment already added, returning cached result")
+	return true, types.PrintResult(cachedConf.Result, netConf.CNIVersion)
+}
+
+func cmdAdd(args *skel.CmdArgs) (retErr error) {
+	// The runtime may retry ADD, the VF is already configured in that case
+	if repeated, err := handleRepeatedAdd(args); repeated {
+		return err
+	}
+
+	netConf, netns, err := getNetConfNetns(args)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = netns.Close() }()
+
+	// Lock CNI operation to serialize the operation
+	lock, err := lockCNIExecution(netConf)
+	if err != nil {
+		return err
+	}
+	defer unlockCNIExecution(lock)
+
+	netConf.ContainerID = args.ContainerID
+	netConf.IfName = args.IfName
+	netConf.StdinData = args.StdinData
+	netConf.NetnsPath = args.Netns
+
+	result := &current.Result{}
+	result.Interfaces = []*current.Interface{{
+		Name:    args.IfName,
+		Sandbox: netns.Path(),
 	}}
+	// Cached along with the NetConf for repeated ADD
+	netConf.ConfigHash = config.GetConfigHash(args)
+	netConf.Result = result
 
 	// Check if device is PF (Physical Function) - flag was set in getNetConfNetns
 	// PF passthrough devices don't need VF configuration
-	if !netConf.IsVFDevice {
+	if !netConf.IsVFDevice && !netConf.PFChildMode {
 		if !netConf.VfioPciMode {
 			return fmt.Errorf("PF device %s requires vfioPciMode to be enabled", netConf.DeviceID)
 		}
 		// PF device - just cache config and return success
+		result.Interfaces[0].PciID = netConf.DeviceID
+		netConf.DevInfoPath = devinfo.GetPath(netConf.Name, args.ContainerID, args.IfName)
+		if err = devinfo.Save(netConf.Name, args.ContainerID, args.IfName, newDeviceInfo(netConf, nil)); err != nil {
+			return err
+		}
 		if err = utils.SaveNetConf(args.ContainerID, config.DefaultCNIDir, args.IfName, netConf); err != nil {
+			cleanDeviceInfo(netConf, args)
 			return fmt.Errorf("error saving NetConf: %v", err)
 		}
 	} else {
 		// VF device - continue with normal VF configuration
-		err = handleVFAdd(args, netConf, netns, result)
+		err = handleVFAdd(args, netConf, netns, result, lock)
 		if err != nil {
+			logging.Error("failed to add attachment", "error", err)
 			return err
 		}
 	}
 
+	logging.Info("attachment added", "guid", netConf.GUID)
 	return types.PrintResult(result, netConf.CNIVersion)
 }
 
@@ -377,7 +955,8 @@ func handleIPAMCleanup(netConf *localtypes.NetConf, stdinData []byte) error {
 }
 
 // handleVFCleanup performs VF-specific cleanup operations
-func handleVFCleanup(sm localtypes.Manager, netConf *localtypes.NetConf, args *skel.CmdArgs, netns ns.NetNS) error {
+func handleVFCleanup(sm localtypes.Manager, netConf *localtypes.NetConf, args *skel.CmdArgs, netns ns.NetNS,
+	lock *cniLock) error {
 	// VFIO devices don't have network interfaces to release
 	if !netConf.VfioPciMode {
 		err := sm.ReleaseVF(netConf, args.IfName, args.ContainerID, netns)
@@ -393,6 +972,12 @@ func handleVFCleanup(sm localtypes.Manager, netConf *localtypes.NetConf, args *s
 	//   1. netedv cleanup during ReleaseVF.
 	//   2. rdma dev netns cleanup as ResetVFConfig will rebind the VF.
 	// Doing anything would have yielded the same results however ResetVFConfig will eventually not trigger VF rebind.
+	unlockRdmaNaming, err := lock.lockRdmaNaming()
+	if err != nil {
+		return err
+	}
+	defer unlockRdmaNaming()
+
 	if netConf.RdmaIsolation {
 		err := utils.MoveRdmaDevFromNs(netConf.RdmaNetState.ContainerRdmaDevName, netns)
 		if err != nil {
@@ -402,6 +987,10 @@ func handleVFCleanup(sm localtypes.Manager, netConf *localtypes.NetConf, args *s
 		}
 	}
 
+	if err := restoreVFNodeDesc(netConf); err != nil {
+		return err
+	}
+
 	if err := sm.ResetVFConfig(netConf); err != nil {
 		return fmt.Errorf("cmdDel() error resetting VF: %v", err)
 	}
@@ -414,24 +1003,71 @@ func cmdDel(args *skel.CmdArgs) (retErr error) {
 		return nil
 	}
 
+	stdinConf, err := config.LoadConf(args.StdinData)
+	if err == nil {
+		initLogging(stdinConf, args)
+		// the device-info file is named after the network, remove it whatever state the attachment is in
+		defer cleanDeviceInfo(stdinConf, args)
+	}
+	defer cleanCDISpec(args)
+
 	netConf, cRefPath, err := config.LoadConfFromCache(args)
+	if err != nil {
+		// ADD may have been interrupted before caching the NetConf
+		undone, jErr := undoJournal(args)
+		if jErr != nil || undone {
+			return jErr
+		}
+		// ADD may have completed while waiting for the lock
+		netConf, cRefPath, err = config.LoadConfFromCache(args)
+	}
 	if err != nil {
 		// According to the CNI spec, a DEL action should complete without errors
 		// even if there are some resources missing. For more details, see
 		//nolint
 		// https://github.com/containernetworking/cni/blob/main/SPEC.md#del-remove-container-from-network-or-un-apply-modifications
+		if stdinConf == nil {
+			logging.Warning("no cached NetConf found, nothing to release", "error", err)
+			return nil
+		}
+		logging.Warning("no cached NetConf found, releasing VF based on network configuration", "error", err)
+		if err = releaseUncachedVF(args, stdinConf); err != nil {
+			logging.Error("failed to release VF based on network configuration", "error", err)
+			return nil
+		}
+		if len(stdinConf.GUIDPool) > 0 {
+			if err = releasePoolGUID(stdinConf, args.ContainerID, args.IfName); err != nil {
+				logging.Error("failed to release GUID to the guidPool", "error", err)
+			}
+		}
+		return nil
+	}
+	logging.AddFields("vf", netConf.VFID)
+
+	// Lock CNI operation to serialize the operation. The lock is released after the cached NetConf is removed, so
+	// that GC does not release the VF again.
+	lock, err := lockCNIExecution(netConf)
+	if err != nil {
+		return err
+	}
+	defer unlockCNIExecution(lock)
+	// the attachment may have been released by GC holding the lock
+	if _, err = os.Stat(cRefPath); os.IsNotExist(err) {
+		logging.Info("attachment already released")
 		return nil
 	}
 
 	if cRefPath != "" {
 		defer func() {
-			if retErr == nil {
-				_ = utils.CleanCachedNetConf(cRefPath)
+			if retErr != nil {
+				logging.Error("failed to delete attachment", "error", retErr)
+				return
 			}
+			removeDeletedAttachment(netConf, cRefPath, args)
 		}()
 	}
 
-	sm := sriov.NewSriovManager()
+	sm := newManager(netConf)
 
 	if netConf.IPAM.Type != "" {
 		err = handleIPAMCleanup(netConf, args.StdinData)
@@ -440,44 +1076,599 @@ func cmdDel(args *skel.CmdArgs) (retErr error) {
 		}
 	}
 
+	// Detect if device is VF or PF at runtime during Del
+	hasNetdev, err := hasPodNetdev(netConf)
+	if err != nil {
+		return err
+	}
+
+	// PF devices don't need VF cleanup
+	if !hasNetdev {
+		return nil
+	}
+
 	netns, err := ns.GetNS(args.Netns)
 	if err != nil {
 		// according to:
 		// https://github.com/kubernetes/kubernetes/issues/43014#issuecomment-287164444
 		// if provided path does not exist (e.x. when node was restarted)
-		// plugin should silently return with success after releasing
-		// IPAM resources
+		// plugin should not fail, the VF is still reset as it keeps the pod GUID otherwise
 		_, ok := err.(ns.NSPathNotExistErr)
 		if ok {
-			return nil
+			logging.Info("pod netns no longer exists", "netns", args.Netns)
+			return resetVFOfGoneNetns(sm, netConf, lock)
 		}
 
 		return fmt.Errorf("failed to open netns %s: %q", netns, err)
 	}
 	defer func() { _ = netns.Close() }()
 
-	// Detect if device is VF or PF at runtime during Del
+	return handleVFCleanup(sm, netConf, args, netns, lock)
+}
+
+// removeDeletedAttachment returns the GUID of a deleted attachment to the guidPool and removes its cached NetConf
+func removeDeletedAttachment(netConf *localtypes.NetConf, cRefPath string, args *skel.CmdArgs) {
+	if len(netConf.GUIDPool) > 0 {
+		if err := releasePoolGUID(netConf, args.ContainerID, args.IfName); err != nil {
+			logging.Error("failed to release GUID to the guidPool", "error", err)
+		}
+	}
+	if err := utils.CleanCachedNetConf(cRefPath); err != nil {
+		logging.Error("failed to remove cached NetConf", "error", err)
+	}
+	// ADD may have been interrupted after caching the NetConf
+	if err := journal.Discard(config.GetJournalPath(args.ContainerID, args.IfName)); err != nil {
+		logging.Error("failed to remove journal", "error", err)
+	}
+	logging.Info("attachment deleted")
+}
+
+// releaseUncachedVF is a best effort release of the VF of an attachment whose cached NetConf is missing.
+// It relies on the network configuration passed to DEL and on the current state of the VF.
+func releaseUncachedVF(args *skel.CmdArgs, netConf *localtypes.NetConf) error {
+	if netConf.PFChildMode {
+		return releaseUncachedPFChild(args, netConf)
+	}
+	if netConf.DeviceID == "" {
+		return nil
+	}
 	isVF, err := utils.IsVirtualFunction(netConf.DeviceID)
 	if err != nil {
 		return fmt.Errorf("failed to determine if device %s is VF or PF: %v", netConf.DeviceID, err)
 	}
+	if err = handleVfioPciDetection(netConf); err != nil {
+		return err
+	}
+
+	if netConf.IPAM.Type != "" {
+		if err = handleIPAMCleanup(netConf, args.StdinData); err != nil {
+			return err
+		}
+	}
 
 	// PF devices don't need VF cleanup
 	if !isVF {
 		return nil
 	}
 
-	// Lock CNI operation to serialize the operation
-	lock, err := lockCNIExecution()
+	// the VF index must be resolved, a wrong one would release the VF of another pod
+	if err = config.LoadVFInfo(netConf); err != nil {
+		return err
+	}
+	// VF netdevice is not found in the default namespace while it is in the pod namespace
+	if err = config.LoadDeviceInfo(netConf); err != nil {
+		logging.Debug("VF netdevice not found in the default namespace", "error", err)
+	}
+	logging.AddFields("vf", netConf.VFID)
+	if netConf.GUID, err = getGUIDFromConf(netConf, args); err != nil {
+		return err
+	}
+	if netConf.GUID == "" && len(netConf.GUIDPool) > 0 {
+		guid, err := guidpool.Lookup(config.GetGUIDPoolDir(), config.GetAttachmentID(args.ContainerID, args.IfName),
+			config.GetLockTimeout(netConf))
+		if err != nil {
+			return err
+		}
+		netConf.GUID = guid
+	}
+
+	lock, err := lockCNIExecution(netConf)
 	if err != nil {
 		return err
 	}
 	defer unlockCNIExecution(lock)
 
-	return handleVFCleanup(sm, netConf, args, netns)
+	sm := sriov.NewSriovManager()
+	attached := false
+	if netns, nsErr := ns.GetNS(args.Netns); nsErr == nil {
+		attached, err = releaseVFFromNs(sm, netConf, args, netns)
+		_ = netns.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// VF may have been returned to the default namespace by the kernel, it still carries the attachment GUID
+	portGUID := netConf.GetPortGUID()
+	if !attached && (portGUID == "" || !strings.EqualFold(getHostVFGUID(netConf), portGUID)) {
+		logging.Info("VF is not attached to the pod, nothing to release")
+		return nil
+	}
+
+	unlockRdmaNaming, err := lock.lockRdmaNaming()
+	if err != nil {
+		return err
+	}
+	defer unlockRdmaNaming()
+
+	netConf.HostIFNodeGUID = utils.DefaultGUID
+	netConf.HostIFPortGUID = utils.DefaultGUID
+	if err = sm.ResetVFConfig(netConf); err != nil {
+		return fmt.Errorf("error resetting VF: %v", err)
+	}
+	logging.Info("released VF based on network configuration")
+	return nil
+}
+
+// releaseUncachedPFChild deletes the IPoIB child of the PF of an attachment whose cached NetConf is missing
+func releaseUncachedPFChild(args *skel.CmdArgs, netConf *localtypes.NetConf) error {
+	if netConf.IPAM.Type != "" {
+		if err := handleIPAMCleanup(netConf, args.StdinData); err != nil {
+			return err
+		}
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		// the IPoIB child is destroyed along with the pod netns
+		return nil
+	}
+	defer func() { _ = netns.Close() }()
+
+	if err = ipoib.NewIPoIBManager().ReleaseVF(netConf, args.IfName, args.ContainerID, netns); err != nil {
+		return err
+	}
+	logging.Info("released IPoIB child based on network configuration")
+	return nil
+}
+
+// releaseVFFromNs moves the VF netdevice and RDMA device found in the pod namespace back to the default namespace.
+// It returns true if any of them was found.
+func releaseVFFromNs(sm localtypes.Manager, netConf *localtypes.NetConf, args *skel.CmdArgs, netns ns.NetNS) (bool, error) {
+	attached := false
+	// the RDMA device is found by its node GUID
+	guid := netConf.GetNodeGUID()
+
+	if !netConf.VfioPciMode {
+		podLinkGUID, err := getPodVFLinkGUID(netConf.DeviceID, args.IfName, netns)
+		if err != nil {
+			return false, err
+		}
+		if podLinkGUID != "" {
+			// The name the VF had before the attachment is unknown, move it back under a temporary one
+			// which is replaced by the kernel given name when resetting the VF config
+			netConf.ContIFNames = args.IfName
+			netConf.HostIFNames = fmt.Sprintf("%s%d", uncachedVFNamePrefix, netConf.VFID)
+			if err = sm.ReleaseVF(netConf, args.IfName, args.ContainerID, netns); err != nil {
+				return false, err
+			}
+			netConf.HostIFNames = ""
+			logging.Debug("moved VF netdevice back to default namespace", "ifname", args.IfName)
+			attached = true
+			// the port GUID of the pod interface is also the node GUID unless they are configured separately
+			if guid == "" {
+				guid = podLinkGUID
+			}
+		}
+	}
+
+	if netConf.RdmaIsolation && guid != "" {
+		rdmaDev, err := utils.GetRdmaDevInNsByGUID(guid, netns)
+		if err != nil {
+			return false, err
+		}
+		if rdmaDev != "" {
+			if err = utils.MoveRdmaDevFromNs(rdmaDev, netns); err != nil {
+				return false, err
+			}
+			logging.Debug("moved RDMA device back to default namespace", "rdmaDev", rdmaDev)
+			attached = true
+		}
+	}
+	return attached, nil
+}
+
+// getPodVFLinkGUID returns the GUID of the pod interface if it is the VF netdevice, or an empty string otherwise
+func getPodVFLinkGUID(deviceID, ifName string, netns ns.NetNS) (string, error) {
+	var guid string
+	err := netns.Do(func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			if _, ok := err.(netlink.LinkNotFoundError); ok {
+				return nil
+			}
+			return err
+		}
+		// parent device is reported by recent kernels only
+		if link.Attrs().ParentDev != "" && link.Attrs().ParentDev != deviceID {
+			return nil
+		}
+		guid = utils.GetGUIDFromHwAddr(link.Attrs().HardwareAddr)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to lookup pod interface %s: %v", ifName, err)
+	}
+	return guid, nil
+}
+
+// getHostVFGUID returns the GUID of the VF netdevice in the default namespace, or an empty string if unknown
+func getHostVFGUID(netConf *localtypes.NetConf) string {
+	if netConf.HostIFNames == "" {
+		return ""
+	}
+	link, err := netlink.LinkByName(netConf.HostIFNames)
+	if err != nil {
+		return ""
+	}
+	return utils.GetGUIDFromHwAddr(link.Attrs().HardwareAddr)
+}
+
+// undoJournal reverts the steps completed by an interrupted ADD and removes its journal.
+// It returns false if the attachment has no journal.
+func undoJournal(args *skel.CmdArgs) (bool, error) {
+	journalPath := config.GetJournalPath(args.ContainerID, args.IfName)
+	j, err := journal.Load(journalPath)
+	if err != nil || j == nil {
+		return false, err
+	}
+
+	lock, err := lockCNIExecution(j.NetConf)
+	if err != nil {
+		return false, err
+	}
+	defer unlockCNIExecution(lock)
+
+	// ADD may have completed or rolled back while waiting for the lock
+	j, err = journal.Load(journalPath)
+	if err != nil || j == nil {
+		return false, err
+	}
+
+	logging.Info("reverting interrupted ADD", "journal", j.Path(), "steps", j.Steps)
+	unlockRdmaNaming, err := lock.lockRdmaNaming()
+	if err != nil {
+		return false, err
+	}
+	defer unlockRdmaNaming()
+
+	if err = j.Undo(newManager(j.NetConf), args.StdinData); err != nil {
+		logging.Error("failed to revert interrupted ADD", "error", err)
+		return false, fmt.Errorf("failed to revert interrupted ADD of %s: %v", j.Path(), err)
+	}
+	if err = j.Remove(); err != nil {
+		return false, err
+	}
+	logging.Info("interrupted ADD reverted")
+	return true, nil
+}
+
+// checkInterfaceIPs verifies that IPs reported in prevResult are still configured on the pod interface
+func checkInterfaceIPs(prevResult *current.Result, ifName string, netns ns.NetNS) error {
+	var ips []*current.IPConfig
+	for _, ipc := range prevResult.IPs {
+		if ipc.Interface == nil || *ipc.Interface >= len(prevResult.Interfaces) ||
+			prevResult.Interfaces[*ipc.Interface].Name == ifName {
+			ips = append(ips, ipc)
+		}
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		return ip.ValidateExpectedInterfaceIPs(ifName, ips)
+	})
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
+	netConf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return fmt.Errorf("infiniBand SRI-OV CNI failed to load netconf: %v", err)
+	}
+	initLogging(netConf, args)
+
+	if netConf.RawPrevResult == nil {
+		return fmt.Errorf("required prevResult missing")
+	}
+	if err = cniVersion.ParsePrevResult(&netConf.PluginConf); err != nil {
+		return fmt.Errorf("failed to parse prevResult: %v", err)
+	}
+	prevResult, err := current.NewResultFromResult(netConf.PrevResult)
+	if err != nil {
+		return fmt.Errorf("failed to convert prevResult: %v", err)
+	}
+
+	cachedConf, _, err := config.LoadConfFromCache(args)
+	if err != nil {
+		return fmt.Errorf("failed to load cached NetConf of interface %q: %v", args.IfName, err)
+	}
+
+	if cachedConf.IPAM.Type != "" && !cachedConf.VfioPciMode {
+		if err = ipam.ExecCheck(cachedConf.IPAM.Type, args.StdinData); err != nil {
+			return err
+		}
+	}
+
+	hasNetdev, err := hasPodNetdev(cachedConf)
+	if err != nil {
+		return err
+	}
+
+	// PF passthrough devices are not configured by the plugin
+	if !hasNetdev {
+		return nil
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %v", args.Netns, err)
+	}
+	defer func() { _ = netns.Close() }()
+
+	sm := newManager(cachedConf)
+	if err = sm.CheckVFConfig(cachedConf, args.IfName, netns); err != nil {
+		return fmt.Errorf("VF %s check failed: %v", cachedConf.DeviceID, err)
+	}
+
+	if cachedConf.RdmaIsolation {
+		if err = utils.CheckRdmaDevInNs(cachedConf.RdmaNetState.ContainerRdmaDevName, netns); err != nil {
+			return err
+		}
+	}
+
+	// VFIO devices don't have network interfaces to hold IPs
+	if cachedConf.VfioPciMode {
+		return nil
+	}
+
+	if err = checkInterfaceIPs(prevResult, args.IfName, netns); err != nil {
+		return fmt.Errorf("interface %q IP check failed: %v", args.IfName, err)
+	}
+
+	return nil
+}
+
+// resetVFOfGoneNetns resets the VF of an attachment whose pod network namespace no longer exists.
+// The kernel returns the VF netdevice and RDMA device to the default namespace once the namespace is destroyed,
+// only the VF configuration and netdevice name need to be restored.
+func resetVFOfGoneNetns(sm localtypes.Manager, netConf *localtypes.NetConf, lock *cniLock) error {
+	// the IPoIB child of the PF is destroyed along with the namespace
+	if netConf.PFChildMode {
+		return nil
+	}
+
+	// VFIO devices don't have network interfaces nor RDMA devices
+	if !netConf.VfioPciMode {
+		if _, err := utils.WaitForVFNetdev(netConf.DeviceID, vfReturnTimeout); err != nil {
+			return err
+		}
+		if netConf.RdmaIsolation {
+			if _, err := utils.WaitForPciRdmaDev(netConf.DeviceID, vfReturnTimeout); err != nil {
+				return err
+			}
+		}
+	}
+
+	unlockRdmaNaming, err := lock.lockRdmaNaming()
+	if err != nil {
+		return err
+	}
+	defer unlockRdmaNaming()
+
+	if err = restoreVFNodeDesc(netConf); err != nil {
+		return err
+	}
+	// also restores the VF netdevice name, IPoIB mode and MTU
+	if err = sm.ResetVFConfig(netConf); err != nil {
+		return fmt.Errorf("error resetting VF: %v", err)
+	}
+	return nil
+}
+
+// gcAttachment releases the VF held by a cached attachment which is no longer valid
+func gcAttachment(sm localtypes.Manager, netConf *localtypes.NetConf, lock *cniLock) error {
+	hasNetdev, err := hasPodNetdev(netConf)
+	if err != nil {
+		return err
+	}
+
+	// PF devices don't need VF cleanup
+	if !hasNetdev {
+		return nil
+	}
+
+	netns, err := ns.GetNS(netConf.NetnsPath)
+	if err != nil {
+		if _, ok := err.(ns.NSPathNotExistErr); !ok && netConf.NetnsPath != "" {
+			return fmt.Errorf("failed to open netns %s: %q", netConf.NetnsPath, err)
+		}
+		return resetVFOfGoneNetns(sm, netConf, lock)
+	}
+	defer func() { _ = netns.Close() }()
+
+	args := &skel.CmdArgs{
+		IfName: netConf.ContIFNames,
+		Netns:  netConf.NetnsPath,
+	}
+	return handleVFCleanup(sm, netConf, args, netns, lock)
+}
+
+// releaseStaleAttachment releases a stale attachment and removes its cached NetConf while holding the lock
+// guarding its device. The attachment is skipped if it was deleted by a CNI invocation holding the lock.
+func releaseStaleAttachment(cRefPath string, netConf *localtypes.NetConf) error {
+	lock, err := lockCNIExecution(netConf)
+	if err != nil {
+		return err
+	}
+	defer unlockCNIExecution(lock)
+	if _, err = os.Stat(cRefPath); os.IsNotExist(err) {
+		logging.Debug("stale attachment already deleted", "cache", cRefPath)
+		return nil
+	}
+
+	if err = gcAttachment(newManager(netConf), netConf, lock); err != nil {
+		logging.Error("failed to release stale attachment", "cache", cRefPath, "deviceID", netConf.DeviceID,
+			"error", err)
+		return fmt.Errorf("failed to release attachment %s: %v", cRefPath, err)
+	}
+
+	if err = releaseStaleIPAM(netConf); err != nil {
+		return fmt.Errorf("failed to release IPAM allocation of attachment %s: %v", cRefPath, err)
+	}
+
+	if err = config.RemoveAttachmentFiles(netConf, ""); err != nil {
+		return err
+	}
+
+	if len(netConf.GUIDPool) > 0 {
+		if err = guidpool.Release(config.GetGUIDPoolDir(), filepath.Base(cRefPath), config.GetLockTimeout(netConf)); err != nil {
+			return err
+		}
+	}
+
+	if err = utils.CleanCachedNetConf(cRefPath); err != nil {
+		return err
+	}
+	logging.Info("released stale attachment", "cache", cRefPath, "deviceID", netConf.DeviceID)
+	return nil
+}
+
+// releaseStaleIPAM runs IPAM DEL for a stale attachment with the network configuration of its ADD, as DEL does.
+// Attachments cached by older versions lack it and are left to the IPAM GC.
+func releaseStaleIPAM(netConf *localtypes.NetConf) error {
+	if netConf.VfioPciMode || netConf.IPAM.Type == "" || netConf.IPAM.Type == ipamDHCP || len(netConf.StdinData) == 0 {
+		return nil
+	}
+
+	cniPath := os.Getenv("CNI_PATH")
+	pluginPath, err := invoke.FindInPath(netConf.IPAM.Type, filepath.SplitList(cniPath))
+	if err != nil {
+		return err
+	}
+	return invoke.ExecPluginWithoutResult(context.TODO(), pluginPath, netConf.StdinData, &invoke.Args{
+		Command:     "DEL",
+		ContainerID: netConf.ContainerID,
+		NetNS:       netConf.NetnsPath,
+		IfName:      netConf.IfName,
+		Path:        cniPath,
+	}, nil)
+}
+
+func cmdGC(args *skel.CmdArgs) error {
+	netConf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return fmt.Errorf("infiniBand SRI-OV CNI failed to load netconf: %v", err)
+	}
+	logging.Init(netConf.LogLevel, netConf.LogFile)
+
+	validAttachments := make(map[string]bool, len(netConf.ValidAttachments))
+	for _, attachment := range netConf.ValidAttachments {
+		validAttachments[config.GetCachedConfPath(attachment.ContainerID, attachment.IfName)] = true
+	}
+
+	cRefPaths, err := config.ListCachedConfs()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, cRefPath := range cRefPaths {
+		if validAttachments[cRefPath] {
+			continue
+		}
+
+		cachedConf, err := config.LoadConfFromCacheFile(cRefPath)
+		if err != nil {
+			// deleted since it was listed
+			if _, sErr := os.Stat(cRefPath); os.IsNotExist(sErr) {
+				continue
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		// Only attachments of the network being garbage collected are handled
+		if cachedConf.Name != netConf.Name {
+			continue
+		}
+
+		if err = releaseStaleAttachment(cRefPath, cachedConf); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Let IPAM release addresses of attachments which are no longer valid
+	if netConf.IPAM.Type != "" {
+		if err = invoke.DelegateGC(context.TODO(), netConf.IPAM.Type, args.StdinData, nil); err != nil {
+			errs = append(errs, fmt.Errorf("failed to run IPAM GC: %v", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkIBPortsActive verifies that at least one SR-IOV enabled InfiniBand PF has an ACTIVE port
+func checkIBPortsActive(master string, pfChildMode bool) error {
+	var pfs []string
+	if pfChildMode {
+		// the IPoIB child is created on master, which doesn't need SR-IOV VFs
+		if !utils.IsInfinibandNetdev(master) {
+			return types.NewError(errPluginNotAvailable,
+				fmt.Sprintf("master %s is not an InfiniBand netdevice", master), "")
+		}
+		pfs = []string{master}
+	} else {
+		var err error
+		if pfs, err = utils.GetSriovIBPfs(); err != nil {
+			return types.NewError(errPluginNotAvailable, "failed to list InfiniBand PFs", err.Error())
+		}
+		if master != "" {
+			if !slices.Contains(pfs, master) {
+				return types.NewError(errPluginNotAvailable,
+					fmt.Sprintf("master %s is not an SR-IOV enabled InfiniBand PF", master), "")
+			}
+			pfs = []string{master}
+		}
+		if len(pfs) == 0 {
+			return types.NewError(errPluginNotAvailable, "no SR-IOV enabled InfiniBand PF found", "")
+		}
+	}
+
+	if active, inactive := utils.HasActiveIBPort(pfs); !active {
+		return types.NewError(errLimitedConnectivity, "no ACTIVE InfiniBand port found", strings.Join(inactive, ", "))
+	}
+	return nil
+}
+
+func cmdStatus(args *skel.CmdArgs) error {
+	netConf, err := config.LoadConf(args.StdinData)
+	if err != nil {
+		return fmt.Errorf("infiniBand SRI-OV CNI failed to load netconf: %v", err)
+	}
+	logging.Init(netConf.LogLevel, netConf.LogFile)
+
+	if netConf.RdmaIsolation {
+		if err = utils.EnsureRdmaSystemMode(); err != nil {
+			return types.NewError(errPluginNotAvailable, "RDMA subsystem is not ready", err.Error())
+		}
+	}
+
+	if err = checkIBPortsActive(netConf.Master, netConf.PFChildMode); err != nil {
+		return err
+	}
+
+	if netConf.IPAM.Type != "" && netConf.IPAM.Type != ipamDHCP {
+		return ipam.ExecStatus(netConf.IPAM.Type, args.StdinData)
+	}
+
 	return nil
 }
 
@@ -501,9 +1692,11 @@ func main() {
 
 	skel.PluginMainFuncs(
 		skel.CNIFuncs{
-			Add:   cmdAdd,
-			Del:   cmdDel,
-			Check: cmdCheck,
+			Add:    cmdAdd,
+			Del:    cmdDel,
+			Check:  cmdCheck,
+			GC:     cmdGC,
+			Status: cmdStatus,
 		},
 		cniVersion.All,
 		"")
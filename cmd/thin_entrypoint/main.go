@@ -25,6 +25,8 @@ import (
 	"os/signal"
 	"path/filepath"
 	"syscall"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/sriov"
 )
 
 // copyFileAtomic copies a file atomically by writing to a temporary file first, then renaming.
@@ -101,12 +103,20 @@ type Options struct {
 	CNIBinDir         string
 	IBSriovCNIBinFile string
 	NoSleep           bool
+	Recover           bool
+	CNICacheDir       string
+	HostRoot          string
 }
 
 func (o *Options) addFlags() {
 	flag.StringVar(&o.CNIBinDir, "cni-bin-dir", "/host/opt/cni/bin", "CNI binary directory")
 	flag.StringVar(&o.IBSriovCNIBinFile, "ib-sriov-cni-bin-file", "/usr/bin/ib-sriov", "InfiniBand SR-IOV CNI binary file path")
 	flag.BoolVar(&o.NoSleep, "no-sleep", false, "Exit after copying binary instead of sleeping") // Used for testing
+	flag.BoolVar(&o.Recover, "recover", false,
+		"Reclaim VFs of cached attachments whose pod network namespace no longer exists, e.g. after a node reboot")
+	flag.StringVar(&o.CNICacheDir, "cni-cache-dir", "/host/var/lib/cni/ib-sriov", "InfiniBand SR-IOV CNI cache directory")
+	flag.StringVar(&o.HostRoot, "host-root", "/host",
+		"Host root filesystem mount point, used to resolve pod network namespace, CNI lock, device-info and CDI spec paths")
 
 	flag.Usage = func() {
 		fmt.Printf("This is a thin entrypoint for InfiniBand SR-IOV CNI to copy its\n")
@@ -166,6 +176,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Reclaim stale attachments, failures don't prevent the CNI from being installed
+	if opt.Recover {
+		summary, err := opt.recoverAttachments(sriov.NewSriovManager())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: recovery failed: %v\n", err)
+		} else {
+			summary.print()
+		}
+	}
+
 	// Exit immediately if --no-sleep is specified
 	if opt.NoSleep {
 		fmt.Println("Binary copied successfully, exiting (--no-sleep)")
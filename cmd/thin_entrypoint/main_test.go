@@ -3,13 +3,23 @@ package main
 // disable dot-imports only for testing
 //revive:disable:dot-imports
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/gofrs/flock"
 	. "github.com/onsi/ginkgo/v2" //nolint:golint
 	. "github.com/onsi/gomega"    //nolint:golint
+	"github.com/stretchr/testify/mock"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/guidpool"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/journal"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types/mocks"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
 )
 
 func TestThinEntrypoint(t *testing.T) {
@@ -257,6 +267,205 @@ var _ = Describe("IB SR-IOV thin entrypoint", func() {
 		})
 	})
 
+	Describe("recoverAttachments", func() {
+		var (
+			tmpDir          string
+			opt             *Options
+			origCNIDir      string
+			origSysBusPci   string
+			origNetDir      string
+			origIBDirectory string
+		)
+
+		cacheConf := func(containerID, deviceID, netnsPath string) {
+			netConf := &types.NetConf{}
+			netConf.DeviceID = deviceID
+			netConf.NetnsPath = netnsPath
+			Expect(utils.SaveNetConf(containerID, opt.CNICacheDir, "net1", netConf)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = os.MkdirTemp("", "ib_sriov_thin_entrypoint_tmp")
+			Expect(err).NotTo(HaveOccurred())
+
+			opt = &Options{
+				CNICacheDir: filepath.Join(tmpDir, "cache"),
+				HostRoot:    filepath.Join(tmpDir, "host"),
+			}
+			Expect(os.MkdirAll(filepath.Join(opt.HostRoot, "var/run/netns/alive"), 0755)).To(Succeed())
+
+			origCNIDir = config.DefaultCNIDir
+			origSysBusPci, origNetDir, origIBDirectory = utils.SysBusPci, utils.NetDirectory, utils.InfinibandDirectory
+			Expect(utils.CreateTmpSysFs()).To(Succeed())
+		})
+		AfterEach(func() {
+			Expect(utils.RemoveTmpSysFs()).To(Succeed())
+			utils.SysBusPci, utils.NetDirectory, utils.InfinibandDirectory = origSysBusPci, origNetDir, origIBDirectory
+			config.DefaultCNIDir = origCNIDir
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+
+		It("should reclaim attachments whose netns is gone", func() {
+			cacheConf("gone", "0000:af:06.0", "/var/run/netns/gone")
+			cacheConf("alive", "0000:af:06.0", "/var/run/netns/alive")
+			cacheConf("removed-vf", "0000:af:07.0", "/var/run/netns/gone")
+			cacheConf("legacy", "0000:af:06.0", "")
+
+			netConf := &types.NetConf{}
+			netConf.DeviceID = "0000:af:06.1"
+			netConf.NetnsPath = "/var/run/netns/gone"
+			config.DefaultCNIDir = opt.CNICacheDir
+			j := journal.New(config.GetJournalPath("interrupted", "net1"), "interrupted", "net1", netConf)
+			Expect(j.Record(journal.StepVFConfig)).To(Succeed())
+
+			sm := &mocks.Manager{}
+			sm.On("ResetVFConfig", mock.Anything).Return(nil).Twice()
+
+			summary, err := opt.recoverAttachments(sm)
+			Expect(err).NotTo(HaveOccurred())
+			sm.AssertExpectations(GinkgoT())
+			Expect(summary.Reclaimed).To(HaveLen(3))
+			Expect(summary.Kept).To(Equal(2))
+			Expect(summary.Failed).To(BeEmpty())
+
+			Expect(filepath.Join(opt.CNICacheDir, "gone-net1")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(opt.CNICacheDir, "removed-vf-net1")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(opt.CNICacheDir, "alive-net1")).To(BeAnExistingFile())
+			Expect(filepath.Join(opt.CNICacheDir, "legacy-net1")).To(BeAnExistingFile())
+			Expect(j.Path()).NotTo(BeAnExistingFile())
+		})
+
+		It("should remove the device-info file and the CDI spec of reclaimed attachments", func() {
+			netConf := &types.NetConf{}
+			netConf.DeviceID = "0000:af:06.0"
+			netConf.NetnsPath = "/var/run/netns/gone"
+			netConf.DevInfoPath = "/var/run/k8s.cni.cncf.io/devinfo/cni/mynet-gone-net1-device-info.json"
+			netConf.CDISpecPath = "/var/run/cdi/ib-sriov-gone-net1.json"
+			Expect(utils.SaveNetConf("gone", opt.CNICacheDir, "net1", netConf)).To(Succeed())
+			for _, path := range []string{netConf.DevInfoPath, netConf.CDISpecPath} {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(opt.HostRoot, path)), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(opt.HostRoot, path), []byte("{}"), 0600)).To(Succeed())
+			}
+
+			sm := &mocks.Manager{}
+			sm.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			summary, err := opt.recoverAttachments(sm)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Reclaimed).To(HaveLen(1))
+			Expect(filepath.Join(opt.HostRoot, netConf.DevInfoPath)).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(opt.HostRoot, netConf.CDISpecPath)).NotTo(BeAnExistingFile())
+		})
+
+		It("should keep attachments whose VF reset failed", func() {
+			cacheConf("gone", "0000:af:06.0", "/var/run/netns/gone")
+
+			sm := &mocks.Manager{}
+			sm.On("ResetVFConfig", mock.Anything).Return(errors.New("failed"))
+
+			summary, err := opt.recoverAttachments(sm)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Reclaimed).To(BeEmpty())
+			Expect(summary.Failed).To(HaveLen(1))
+			Expect(filepath.Join(opt.CNICacheDir, "gone-net1")).To(BeAnExistingFile())
+		})
+
+		It("should reclaim IPoIB child attachments of a PF without resetting the PF", func() {
+			netConf := &types.NetConf{}
+			netConf.Master = "ib0"
+			netConf.DeviceID = "0000:af:00.1"
+			netConf.PFChildMode = true
+			netConf.NetnsPath = "/var/run/netns/gone"
+			Expect(utils.SaveNetConf("pfchild", opt.CNICacheDir, "net1", netConf)).To(Succeed())
+			config.DefaultCNIDir = opt.CNICacheDir
+			j := journal.New(config.GetJournalPath("interrupted", "net1"), "interrupted", "net1", netConf)
+			Expect(j.Record(journal.StepVFConfig)).To(Succeed())
+
+			sm := &mocks.Manager{}
+			summary, err := opt.recoverAttachments(sm)
+			Expect(err).NotTo(HaveOccurred())
+			sm.AssertNotCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+			Expect(summary.Reclaimed).To(HaveLen(2))
+			Expect(filepath.Join(opt.CNICacheDir, "pfchild-net1")).NotTo(BeAnExistingFile())
+			Expect(j.Path()).NotTo(BeAnExistingFile())
+		})
+
+		It("should release the guidPool GUID of reclaimed attachments", func() {
+			netConf := &types.NetConf{}
+			netConf.DeviceID = "0000:af:06.0"
+			netConf.NetnsPath = "/var/run/netns/gone"
+			netConf.GUIDPool = []string{"02:00:00:00:00:00:00:01"}
+			Expect(utils.SaveNetConf("gone", opt.CNICacheDir, "net1", netConf)).To(Succeed())
+			config.DefaultCNIDir = opt.CNICacheDir
+			pool, err := guidpool.New(config.GetGUIDPoolDir(), netConf.GUIDPool, config.DefaultLockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pool.Allocate(config.GetAttachmentID("gone", "net1"), "ibnet")).To(Equal("02:00:00:00:00:00:00:01"))
+
+			sm := &mocks.Manager{}
+			sm.On("ResetVFConfig", mock.Anything).Return(nil)
+			summary, err := opt.recoverAttachments(sm)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Reclaimed).To(HaveLen(1))
+			Expect(guidpool.Lookup(config.GetGUIDPoolDir(), config.GetAttachmentID("gone", "net1"), config.DefaultLockTimeout)).To(BeEmpty())
+		})
+
+		It("should keep attachments whose netns directory is missing or unreadable", func() {
+			// the netns directory of the host is not mounted
+			cacheConf("unmounted", "0000:af:06.0", "/run/netns/gone")
+			// the netns directory can't be listed
+			Expect(os.WriteFile(filepath.Join(opt.HostRoot, "var/run/netns/file"), nil, 0644)).To(Succeed())
+			cacheConf("unreadable", "0000:af:06.0", "/var/run/netns/file/gone")
+
+			sm := &mocks.Manager{}
+			summary, err := opt.recoverAttachments(sm)
+			Expect(err).NotTo(HaveOccurred())
+			sm.AssertNotCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+			Expect(summary.Reclaimed).To(BeEmpty())
+			Expect(summary.Kept).To(Equal(1))
+			Expect(summary.Failed).To(HaveLen(1))
+			Expect(filepath.Join(opt.CNICacheDir, "unmounted-net1")).To(BeAnExistingFile())
+			Expect(filepath.Join(opt.CNICacheDir, "unreadable-net1")).To(BeAnExistingFile())
+		})
+
+		It("should wait for the lock of the attachment lockScope", func() {
+			netConf := &types.NetConf{}
+			netConf.DeviceID = "0000:af:06.0"
+			netConf.NetnsPath = "/var/run/netns/gone"
+			netConf.LockScope = config.LockScopeVF
+			Expect(utils.SaveNetConf("gone", opt.CNICacheDir, "net1", netConf)).To(Succeed())
+
+			lockFile := filepath.Join(opt.HostRoot, config.GetLockFilePath(netConf))
+			Expect(os.MkdirAll(filepath.Dir(lockFile), 0755)).To(Succeed())
+			lock := flock.New(lockFile)
+			Expect(lock.Lock()).To(Succeed())
+
+			sm := &mocks.Manager{}
+			sm.On("ResetVFConfig", mock.Anything).Return(nil)
+			done := make(chan *recoverSummary)
+			go func() {
+				defer GinkgoRecover()
+				summary, err := opt.recoverAttachments(sm)
+				Expect(err).NotTo(HaveOccurred())
+				done <- summary
+			}()
+
+			Consistently(done, "200ms").ShouldNot(Receive())
+			sm.AssertNotCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+			Expect(lock.Unlock()).To(Succeed())
+			var summary *recoverSummary
+			Eventually(done, "5s").Should(Receive(&summary))
+			Expect(summary.Reclaimed).To(HaveLen(1))
+		})
+
+		It("should succeed with missing cache directory", func() {
+			summary, err := opt.recoverAttachments(&mocks.Manager{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(summary.Reclaimed).To(BeEmpty())
+			Expect(summary.Kept).To(BeZero())
+		})
+	})
+
 	Describe("Options", func() {
 		It("should handle addFlags correctly", func() {
 			// Test that addFlags doesn't panic
@@ -0,0 +1,250 @@
+// Copyright (c) 2025 InfiniBand SR-IOV CNI Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/guidpool"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/journal"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
+)
+
+// recoverSummary holds the outcome of the recovery pass
+type recoverSummary struct {
+	Reclaimed []string
+	Kept      int
+	Failed    []string
+}
+
+func (s *recoverSummary) print() {
+	fmt.Printf("Recovery finished: %d attachment(s) reclaimed, %d kept, %d failed\n",
+		len(s.Reclaimed), s.Kept, len(s.Failed))
+	for _, r := range s.Reclaimed {
+		fmt.Printf("  reclaimed: %s\n", r)
+	}
+	for _, f := range s.Failed {
+		fmt.Printf("  failed: %s\n", f)
+	}
+}
+
+// recoverAttachments reclaims the VFs of cached attachments and interrupted ADD operations
+// whose pod network namespace no longer exists, e.g. after a node reboot.
+func (o *Options) recoverAttachments(sm types.Manager) (*recoverSummary, error) {
+	config.DefaultCNIDir = o.CNICacheDir
+
+	cRefPaths, err := config.ListCachedConfs()
+	if err != nil {
+		return nil, err
+	}
+	journalPaths, err := config.ListJournals()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &recoverSummary{}
+	for _, cRefPath := range cRefPaths {
+		o.recoverCachedConf(sm, cRefPath, summary)
+	}
+	for _, journalPath := range journalPaths {
+		o.recoverJournal(sm, journalPath, summary)
+	}
+	return summary, nil
+}
+
+func (o *Options) recoverCachedConf(sm types.Manager, cRefPath string, summary *recoverSummary) {
+	netConf, err := config.LoadConfFromCacheFile(cRefPath)
+	if err != nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", cRefPath, err))
+		return
+	}
+
+	unlock, err := o.lockAttachment(netConf)
+	if err != nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", cRefPath, err))
+		return
+	}
+	defer unlock()
+	// the attachment may have been deleted by a CNI invocation holding the lock
+	if _, err = os.Stat(cRefPath); os.IsNotExist(err) {
+		return
+	}
+
+	gone, err := o.isNetnsGone(netConf.NetnsPath)
+	if err != nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", cRefPath, err))
+		return
+	}
+	if !gone {
+		summary.Kept++
+		return
+	}
+
+	if err = resetVF(sm, netConf); err != nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s (VF %s): %v", cRefPath, netConf.DeviceID, err))
+		return
+	}
+	if err = config.RemoveAttachmentFiles(netConf, o.HostRoot); err != nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", cRefPath, err))
+		return
+	}
+	// the cached NetConf is named after the attachment, the owner of its GUID pool allocation
+	if len(netConf.GUIDPool) > 0 {
+		if err = guidpool.Release(config.GetGUIDPoolDir(), filepath.Base(cRefPath), config.GetLockTimeout(netConf)); err != nil {
+			summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", cRefPath, err))
+			return
+		}
+	}
+	if err = utils.CleanCachedNetConf(cRefPath); err != nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", cRefPath, err))
+		return
+	}
+	summary.Reclaimed = append(summary.Reclaimed, fmt.Sprintf("%s (VF %s)", cRefPath, netConf.DeviceID))
+}
+
+func (o *Options) recoverJournal(sm types.Manager, journalPath string, summary *recoverSummary) {
+	j, err := journal.Load(journalPath)
+	if err != nil || j == nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", journalPath, err))
+		return
+	}
+
+	unlock, err := o.lockAttachment(j.NetConf)
+	if err != nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", journalPath, err))
+		return
+	}
+	defer unlock()
+	// the ADD may have completed or been reverted by a CNI invocation holding the lock
+	if _, err = os.Stat(journalPath); os.IsNotExist(err) {
+		return
+	}
+
+	gone, err := o.isNetnsGone(j.NetConf.NetnsPath)
+	if err != nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", journalPath, err))
+		return
+	}
+	if !gone {
+		summary.Kept++
+		return
+	}
+
+	// IPAM allocations are not released as the IPAM configuration is not journaled,
+	// they are reclaimed by the IPAM plugin garbage collection.
+	// The IPoIB child of a PF was destroyed along with the namespace, there is nothing to undo.
+	if !j.NetConf.PFChildMode {
+		if err = j.Undo(sm, nil); err != nil {
+			summary.Failed = append(summary.Failed, fmt.Sprintf("%s (VF %s): %v", journalPath, j.NetConf.DeviceID, err))
+			return
+		}
+	}
+	if err = j.Remove(); err != nil {
+		summary.Failed = append(summary.Failed, fmt.Sprintf("%s: %v", journalPath, err))
+		return
+	}
+	summary.Reclaimed = append(summary.Reclaimed, fmt.Sprintf("%s (VF %s)", journalPath, j.NetConf.DeviceID))
+}
+
+// lockAttachment serializes with CNI invocations on the attachment. It takes the lock of the attachment lockScope,
+// then the global lock, in the order CNI invocations take them, and returns a function releasing them.
+func (o *Options) lockAttachment(netConf *types.NetConf) (func(), error) {
+	lockFiles := []string{config.GetLockFilePath(netConf)}
+	if lockFiles[0] != config.GetGlobalLockFilePath() {
+		lockFiles = append(lockFiles, config.GetGlobalLockFilePath())
+	}
+
+	var locks []*flock.Flock
+	unlock := func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			_ = locks[i].Unlock()
+		}
+	}
+	for _, lockFile := range lockFiles {
+		lockFile = filepath.Join(o.HostRoot, lockFile)
+		if err := os.MkdirAll(filepath.Dir(lockFile), utils.OwnerReadWriteExecuteAttrs); err != nil {
+			unlock()
+			return nil, fmt.Errorf("failed to create lock file directory %q: %v", filepath.Dir(lockFile), err)
+		}
+		lock := flock.New(lockFile)
+		if err := lock.Lock(); err != nil {
+			unlock()
+			return nil, fmt.Errorf("failed to lock %q: %v", lockFile, err)
+		}
+		locks = append(locks, lock)
+	}
+	return unlock, nil
+}
+
+// isNetnsGone checks if the pod network namespace no longer exists on the host. It fails safe: the network
+// namespace is only considered gone if its directory exists and is readable, e.g. the host netns directory
+// missing from the HostRoot mount doesn't reclaim the VFs of running pods.
+func (o *Options) isNetnsGone(netnsPath string) (bool, error) {
+	// attachments cached by older versions don't record their network namespace
+	if netnsPath == "" {
+		return false, nil
+	}
+
+	netnsDir := filepath.Join(o.HostRoot, filepath.Dir(netnsPath))
+	entries, err := os.ReadDir(netnsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check network namespace %q: %v", netnsPath, err)
+	}
+	name := filepath.Base(netnsPath)
+	for _, entry := range entries {
+		if entry.Name() == name {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// resetVF resets the configuration of the VF of an attachment, if the VF still exists
+func resetVF(sm types.Manager, netConf *types.NetConf) error {
+	// the IPoIB child of a PF is destroyed along with the namespace
+	if netConf.PFChildMode {
+		return nil
+	}
+
+	// VFs may not be created yet after a node reboot
+	if _, err := os.Stat(filepath.Join(utils.SysBusPci, netConf.DeviceID)); os.IsNotExist(err) {
+		return nil
+	}
+
+	isVF, err := utils.IsVirtualFunction(netConf.DeviceID)
+	if err != nil {
+		return fmt.Errorf("failed to determine if device %s is VF or PF: %v", netConf.DeviceID, err)
+	}
+	// PF passthrough devices are not configured by the plugin
+	if !isVF {
+		return nil
+	}
+
+	if netConf.HostNodeDesc != "" {
+		if err = utils.SetPciRdmaDevNodeDesc(netConf.DeviceID, netConf.HostNodeDesc); err != nil {
+			return err
+		}
+	}
+	return sm.ResetVFConfig(netConf)
+}
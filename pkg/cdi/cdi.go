@@ -0,0 +1,101 @@
+// Package cdi writes Container Device Interface (CDI) specs of the RDMA char devices of an attachment,
+// so that CDI enabled container runtimes can inject them into the pod containers.
+package cdi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
+)
+
+const (
+	// SpecVersion is the CDI specification version of the generated specs
+	SpecVersion = "0.5.0"
+	// Kind is the kind of the devices of the generated specs
+	Kind = "k8snetworkplumbingwg.io/ib-sriov"
+)
+
+// SpecDir is the directory of dynamically generated CDI specs
+var SpecDir = "/var/run/cdi"
+
+// DeviceNode is a device node created in the container
+type DeviceNode struct {
+	Path string `json:"path"`
+}
+
+// ContainerEdits are the changes applied to a container requesting the device
+type ContainerEdits struct {
+	DeviceNodes []DeviceNode `json:"deviceNodes"`
+}
+
+// Device is a CDI device
+type Device struct {
+	Name           string         `json:"name"`
+	ContainerEdits ContainerEdits `json:"containerEdits"`
+}
+
+// Spec is a CDI spec file
+type Spec struct {
+	Version string   `json:"cdiVersion"`
+	Kind    string   `json:"kind"`
+	Devices []Device `json:"devices"`
+}
+
+// NewSpec returns the spec of a single device giving access to the char devices
+func NewSpec(deviceName string, charDevs []string) *Spec {
+	nodes := make([]DeviceNode, 0, len(charDevs))
+	for _, charDev := range charDevs {
+		nodes = append(nodes, DeviceNode{Path: charDev})
+	}
+	return &Spec{
+		Version: SpecVersion,
+		Kind:    Kind,
+		Devices: []Device{{
+			Name:           deviceName,
+			ContainerEdits: ContainerEdits{DeviceNodes: nodes},
+		}},
+	}
+}
+
+// QualifiedName returns the fully qualified name of the device, used to request it from the runtime
+func QualifiedName(deviceName string) string {
+	return Kind + "=" + deviceName
+}
+
+// GetSpecPath returns the path of the spec of the attachment
+func GetSpecPath(containerID, ifName string) string {
+	return filepath.Join(SpecDir, fmt.Sprintf("ib-sriov-%s-%s.json", containerID, ifName))
+}
+
+// Save writes the spec to path
+func Save(path string, spec *Spec) error {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to serialize CDI spec: %v", err)
+	}
+
+	if err = os.MkdirAll(SpecDir, utils.OwnerReadWriteExecuteOthersReadExecuteAttrs); err != nil {
+		return fmt.Errorf("failed to create CDI spec directory %s: %v", SpecDir, err)
+	}
+
+	// the runtime watches the directory, never let it read a partially written spec
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err = os.WriteFile(tmpPath, data, utils.OwnerReadWriteOthersReadAttrs); err != nil {
+		return fmt.Errorf("failed to write CDI spec %s: %v", tmpPath, err)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write CDI spec %s: %v", path, err)
+	}
+	return nil
+}
+
+// Remove deletes the spec at path if it exists
+func Remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove CDI spec %s: %v", path, err)
+	}
+	return nil
+}
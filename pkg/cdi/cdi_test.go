@@ -0,0 +1,64 @@
+package cdi
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CDI", func() {
+	var (
+		tmpDir      string
+		origSpecDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ib-sriov-cni-cdi-")
+		Expect(err).NotTo(HaveOccurred())
+		origSpecDir = SpecDir
+		SpecDir = filepath.Join(tmpDir, "cdi")
+	})
+	AfterEach(func() {
+		SpecDir = origSpecDir
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	Context("Checking CDI specs", func() {
+		It("Assuming spec saved", func() {
+			spec := NewSpec("default_pod_net1", []string{"/dev/infiniband/uverbs2", "/dev/infiniband/rdma_cm"})
+			path := GetSpecPath("container", "net1")
+			Expect(path).To(Equal(filepath.Join(SpecDir, "ib-sriov-container-net1.json")))
+			Expect(Save(path, spec)).To(Succeed())
+
+			data, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(MatchJSON(`{
+				"cdiVersion": "0.5.0",
+				"kind": "k8snetworkplumbingwg.io/ib-sriov",
+				"devices": [{
+					"name": "default_pod_net1",
+					"containerEdits": {
+						"deviceNodes": [{"path": "/dev/infiniband/uverbs2"}, {"path": "/dev/infiniband/rdma_cm"}]
+					}
+				}]
+			}`))
+			entries, err := os.ReadDir(SpecDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1), "No temporary spec should be left")
+		})
+		It("Assuming qualified device name", func() {
+			Expect(QualifiedName("default_pod_net1")).To(Equal("k8snetworkplumbingwg.io/ib-sriov=default_pod_net1"))
+		})
+		It("Assuming spec removed", func() {
+			path := GetSpecPath("container", "net1")
+			Expect(Save(path, NewSpec("default_pod_net1", []string{"/dev/infiniband/uverbs2"}))).To(Succeed())
+			Expect(Remove(path)).To(Succeed())
+			_, err := os.Stat(path)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(Remove(path)).To(Succeed())
+		})
+	})
+})
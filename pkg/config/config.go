@@ -1,13 +1,26 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
 
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/cdi"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/devinfo"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/guidpool"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/logging"
 	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
 )
@@ -21,6 +34,39 @@ var (
 	CniFileLockName = "cni.lock"
 )
 
+// CNI lock scopes
+const (
+	// LockScopeGlobal serializes all CNI operations on the node
+	LockScopeGlobal = "global"
+	// LockScopePF serializes CNI operations on VFs of the same PF
+	LockScopePF = "pf"
+	// LockScopeVF serializes CNI operations on the same VF
+	LockScopeVF = "vf"
+)
+
+// JournalDirName is the name of the directory under DefaultCNIDir holding the journals of ADD operations
+const JournalDirName = "journal"
+
+// GUID modes
+const (
+	// GUIDModeDerived derives the VF GUID from the pod identity and the network name
+	GUIDModeDerived = "derived"
+	// DefaultGUIDPrefix is the prefix of derived GUIDs when guidPrefix is not configured
+	DefaultGUIDPrefix = "02:00:00"
+)
+
+// guidLen is the length of a GUID in bytes
+const guidLen = 8
+
+// guidPrefixRegexp matches 1 to 7 colon separated hex bytes
+var guidPrefixRegexp = regexp.MustCompile(`^[0-9a-fA-F]{2}(:[0-9a-fA-F]{2}){0,6}$`)
+
+// GUIDPoolDirName is the name of the directory under DefaultCNIDir holding the GUID pool allocations
+const GUIDPoolDirName = "guidpool"
+
+// DefaultLockTimeout is the time to wait for the CNI lock when lockTimeout is not configured
+const DefaultLockTimeout = 120 * time.Second
+
 // LoadConf parses and validates stdin netconf and returns NetConf object
 func LoadConf(bytes []byte) (*types.NetConf, error) {
 	n := &types.NetConf{}
@@ -32,22 +78,249 @@ func LoadConf(bytes []byte) (*types.NetConf, error) {
 	if n.LinkState != "" && n.LinkState != "auto" && n.LinkState != "enable" && n.LinkState != "disable" {
 		return nil, fmt.Errorf("invalid link_state value: %s", n.LinkState)
 	}
+
+	if !logging.IsValidLevel(n.LogLevel) {
+		return nil, fmt.Errorf("invalid logLevel value: %s", n.LogLevel)
+	}
+
+	if n.PKey != "" {
+		if _, err := utils.ParsePKey(n.PKey); err != nil {
+			return nil, fmt.Errorf("invalid pkey value: %v", err)
+		}
+	}
+
+	if n.IPoIBChildPKey && n.PKey == "" {
+		return nil, fmt.Errorf("ipoibChildPKey requires pkey to be set")
+	}
+
+	if n.PFChildMode {
+		if err := validatePFChildMode(n); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateIPoIBConfig(n); err != nil {
+		return nil, err
+	}
+
+	if n.NodeDescription != "" {
+		if _, err := template.New("nodeDescription").Parse(n.NodeDescription); err != nil {
+			return nil, fmt.Errorf("invalid nodeDescription template: %v", err)
+		}
+	}
+
+	if n.LockScope != "" && n.LockScope != LockScopeGlobal && n.LockScope != LockScopePF && n.LockScope != LockScopeVF {
+		return nil, fmt.Errorf("invalid lockScope value: %s", n.LockScope)
+	}
+
+	if n.LockTimeout < 0 {
+		return nil, fmt.Errorf("invalid lockTimeout value: %d", n.LockTimeout)
+	}
+
+	if err := validateWaitForPortActive(n); err != nil {
+		return nil, err
+	}
+
+	// VFIO devices have no RDMA char devices
+	if n.CDISpec && n.VfioPciMode {
+		return nil, fmt.Errorf("cdiSpec is not supported with vfioPciMode")
+	}
+
+	if err := validateGUIDPool(n); err != nil {
+		return nil, err
+	}
+
+	if err := validateGUIDMode(n); err != nil {
+		return nil, err
+	}
+
+	if err := validateNodePortGUIDs(n); err != nil {
+		return nil, err
+	}
 	return n, nil
 }
 
+// validatePFChildMode checks that the configuration applies to an IPoIB child of a PF
+func validatePFChildMode(n *types.NetConf) error {
+	if n.PKey == "" {
+		return fmt.Errorf("pfChildMode requires pkey to be set")
+	}
+	// the RDMA device of the PF is not the pod's, its char devices are not given to the pod
+	if n.VfioPciMode || n.RdmaIsolation || n.IPoIBChildPKey || n.IBKubernetesEnabled || n.LinkState != "" ||
+		n.NodeDescription != "" || n.CDISpec {
+		return fmt.Errorf("pfChildMode is not supported with vfioPciMode, rdmaIsolation, ipoibChildPKey, " +
+			"ibKubernetesEnabled, link_state, nodeDescription or cdiSpec")
+	}
+	return nil
+}
+
+// validateWaitForPortActive checks the timeout of waiting for the VF port to become ACTIVE
+func validateWaitForPortActive(n *types.NetConf) error {
+	if n.WaitForPortActive < 0 {
+		return fmt.Errorf("invalid waitForPortActive value: %d", n.WaitForPortActive)
+	}
+	if n.WaitForPortActive == 0 {
+		if n.WaitForPhysLinkUp {
+			return fmt.Errorf("waitForPhysLinkUp requires waitForPortActive to be set")
+		}
+		return nil
+	}
+	// VFIO devices have no RDMA device and IPoIB children of a PF use the port of the PF
+	if n.VfioPciMode || n.PFChildMode {
+		return fmt.Errorf("waitForPortActive is not supported with vfioPciMode or pfChildMode")
+	}
+	// the wait holds the CNI lock, it must not block the CNI operations of the whole node
+	if n.LockScope != LockScopePF && n.LockScope != LockScopeVF {
+		return fmt.Errorf("waitForPortActive requires lockScope %s or %s", LockScopePF, LockScopeVF)
+	}
+	return nil
+}
+
+// validateGUIDPool checks the GUID pool of the local GUID allocator
+func validateGUIDPool(n *types.NetConf) error {
+	if len(n.GUIDPool) == 0 {
+		return nil
+	}
+	// GUIDs are given by ib-kubernetes and IPoIB children of a PF share the PF GUID
+	if n.IBKubernetesEnabled || n.PFChildMode {
+		return fmt.Errorf("guidPool is not supported with ibKubernetesEnabled or pfChildMode")
+	}
+	return guidpool.Validate(n.GUIDPool)
+}
+
+// validateNodePortGUIDs checks the node and port GUIDs configured separately
+func validateNodePortGUIDs(n *types.NetConf) error {
+	if n.NodeGUID == "" && n.PortGUID == "" {
+		return nil
+	}
+	// IPoIB children of a PF share the PF GUIDs
+	if n.PFChildMode {
+		return fmt.Errorf("nodeGUID and portGUID are not supported with pfChildMode")
+	}
+	if n.NodeGUID != "" && !utils.IsValidGUID(n.NodeGUID) {
+		return fmt.Errorf("invalid nodeGUID value: %s", n.NodeGUID)
+	}
+	if n.PortGUID != "" && !utils.IsValidGUID(n.PortGUID) {
+		return fmt.Errorf("invalid portGUID value: %s", n.PortGUID)
+	}
+	return nil
+}
+
+// validateGUIDMode checks the GUID mode and the prefix of derived GUIDs
+func validateGUIDMode(n *types.NetConf) error {
+	if n.GUIDMode == "" {
+		if n.GUIDPrefix != "" {
+			return fmt.Errorf("guidPrefix requires guidMode to be set")
+		}
+		return nil
+	}
+	if n.GUIDMode != GUIDModeDerived {
+		return fmt.Errorf("invalid guidMode value: %s", n.GUIDMode)
+	}
+	if n.IBKubernetesEnabled || n.PFChildMode || len(n.GUIDPool) > 0 {
+		return fmt.Errorf("guidMode %s is not supported with ibKubernetesEnabled, pfChildMode or guidPool", n.GUIDMode)
+	}
+	if _, err := parseGUIDPrefix(n.GUIDPrefix); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseGUIDPrefix parses the prefix of derived GUIDs, 1 to 7 bytes in colon separated hex
+func parseGUIDPrefix(guidPrefix string) ([]byte, error) {
+	if guidPrefix == "" {
+		guidPrefix = DefaultGUIDPrefix
+	}
+	if !guidPrefixRegexp.MatchString(guidPrefix) {
+		return nil, fmt.Errorf("invalid guidPrefix value: %s", guidPrefix)
+	}
+	prefix, err := hex.DecodeString(strings.ReplaceAll(guidPrefix, ":", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid guidPrefix value: %s", guidPrefix)
+	}
+	return prefix, nil
+}
+
+// DeriveGUID returns the GUID of the attachment derived from the hash of the pod namespace, the pod name and
+// the network name, following the guidPrefix. The GUID is stable when the pod is recreated, e.g. a StatefulSet pod.
+func DeriveGUID(netConf *types.NetConf, args *skel.CmdArgs) (string, error) {
+	k8s, err := loadK8sArgs(args)
+	if err != nil {
+		return "", err
+	}
+	if k8s.K8S_POD_NAMESPACE == "" || k8s.K8S_POD_NAME == "" {
+		return "", fmt.Errorf("guidMode %s requires K8S_POD_NAMESPACE and K8S_POD_NAME in CNI_ARGS", netConf.GUIDMode)
+	}
+	prefix, err := parseGUIDPrefix(netConf.GUIDPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%s", k8s.K8S_POD_NAMESPACE, k8s.K8S_POD_NAME, netConf.Name)))
+	guid := net.HardwareAddr(append(prefix, sum[:guidLen-len(prefix)]...)).String()
+	if !utils.IsValidGUID(guid) || utils.IsAllOnesGUID(guid) {
+		return "", fmt.Errorf("derived GUID %s is not valid", guid)
+	}
+	return guid, nil
+}
+
+// validateIPoIBConfig checks the IPoIB mode and that the MTU is allowed by it. The MTU is checked
+// against the current mode of the VF netdevice during ADD if no mode is configured.
+func validateIPoIBConfig(n *types.NetConf) error {
+	if n.IPoIBMode != "" && n.IPoIBMode != utils.IPoIBModeDatagram && n.IPoIBMode != utils.IPoIBModeConnected {
+		return fmt.Errorf("invalid ipoibMode value: %s", n.IPoIBMode)
+	}
+
+	if n.MTU == 0 {
+		return nil
+	}
+	mode := n.IPoIBMode
+	if mode == "" {
+		mode = utils.IPoIBModeConnected
+	}
+	if err := utils.ValidateIPoIBMTU(mode, n.MTU); err != nil {
+		return fmt.Errorf("invalid mtu value: %v", err)
+	}
+	return nil
+}
+
+// GetGlobalLockFilePath returns the path of the lock file serializing all CNI operations on the node
+func GetGlobalLockFilePath() string {
+	return filepath.Join(CniFileLockDir, CniFileLockName)
+}
+
+// GetLockFilePath returns the path of the lock file guarding operations on the device according to lockScope
+func GetLockFilePath(netConf *types.NetConf) string {
+	switch netConf.LockScope {
+	case LockScopePF:
+		// PF passthrough devices have no master, they are their own PF
+		pf := netConf.Master
+		if pf == "" {
+			pf = netConf.DeviceID
+		}
+		if pf != "" {
+			return filepath.Join(CniFileLockDir, fmt.Sprintf("pf-%s.lock", pf))
+		}
+	case LockScopeVF:
+		if netConf.DeviceID != "" {
+			return filepath.Join(CniFileLockDir, fmt.Sprintf("vf-%s.lock", netConf.DeviceID))
+		}
+	}
+	return GetGlobalLockFilePath()
+}
+
+// GetLockTimeout returns the time to wait for the CNI lock
+func GetLockTimeout(netConf *types.NetConf) time.Duration {
+	if netConf.LockTimeout == 0 {
+		return DefaultLockTimeout
+	}
+	return time.Duration(netConf.LockTimeout) * time.Second
+}
+
 // Load device specific information into netConf
 func LoadDeviceInfo(netConf *types.NetConf) error {
-	// DeviceID takes precedence; if we are given a VF pciaddr then work from there
-	if netConf.DeviceID != "" {
-		// Get rest of the VF information
-		pfName, vfID, err := getVfInfo(netConf.DeviceID)
-		if err != nil {
-			return fmt.Errorf("load config: failed to get VF information: %q", err)
-		}
-		netConf.VFID = vfID
-		netConf.Master = pfName
-	} else {
-		return fmt.Errorf("load config: vf pci addr is required")
+	if err := LoadVFInfo(netConf); err != nil {
+		return err
 	}
 
 	// VFIO devices don't have network interfaces, skip getting interface name
@@ -66,6 +339,47 @@ func LoadDeviceInfo(netConf *types.NetConf) error {
 	return nil
 }
 
+// LoadVFInfo fills in the PF netdevice name and the index of the VF given by deviceID
+func LoadVFInfo(netConf *types.NetConf) error {
+	// DeviceID takes precedence; if we are given a VF pciaddr then work from there
+	if netConf.DeviceID == "" {
+		return fmt.Errorf("load config: vf pci addr is required")
+	}
+	pfName, vfID, err := getVfInfo(netConf.DeviceID)
+	if err != nil {
+		return fmt.Errorf("load config: failed to get VF information: %q", err)
+	}
+	netConf.VFID = vfID
+	netConf.Master = pfName
+	return nil
+}
+
+// LoadPFInfo fills in the PF netdevice name and PCI address of an attachment in pfChildMode,
+// the PF is given by either master or deviceID
+func LoadPFInfo(netConf *types.NetConf) error {
+	switch {
+	case netConf.Master == "" && netConf.DeviceID == "":
+		return fmt.Errorf("load config: either master or deviceID is required")
+	case netConf.Master == "":
+		pfName, err := utils.GetVFLinkNames(netConf.DeviceID)
+		if err != nil || pfName == "" {
+			return fmt.Errorf("load config: failed to detect PF %s name with error, %q", netConf.DeviceID, err)
+		}
+		netConf.Master = pfName
+	case netConf.DeviceID == "":
+		pciAddr, err := utils.GetNetdevPciAddress(netConf.Master)
+		if err != nil {
+			return fmt.Errorf("load config: failed to detect PF %s PCI address with error, %q", netConf.Master, err)
+		}
+		netConf.DeviceID = pciAddr
+	}
+
+	if !utils.IsInfinibandNetdev(netConf.Master) {
+		return fmt.Errorf("load config: %s is not an InfiniBand netdevice", netConf.Master)
+	}
+	return nil
+}
+
 func getVfInfo(vfPci string) (string, int, error) {
 	var vfID int
 
@@ -82,22 +396,201 @@ func getVfInfo(vfPci string) (string, int, error) {
 	return pf, vfID, nil
 }
 
+// GetCachedConfPath returns the path of the cached NetConf of the given attachment
+func GetCachedConfPath(containerID, ifName string) string {
+	return filepath.Join(DefaultCNIDir, GetAttachmentID(containerID, ifName))
+}
+
+// GetAttachmentID returns the identifier of the attachment on the node, the name of its cached NetConf
+func GetAttachmentID(containerID, ifName string) string {
+	s := []string{containerID, ifName}
+	return strings.Join(s, "-")
+}
+
+// GetGUIDPoolDir returns the directory of the GUID pool allocations of the node
+func GetGUIDPoolDir() string {
+	return filepath.Join(DefaultCNIDir, GUIDPoolDirName)
+}
+
+// GetJournalPath returns the path of the ADD journal of the given attachment
+func GetJournalPath(containerID, ifName string) string {
+	s := []string{containerID, ifName}
+	return filepath.Join(DefaultCNIDir, JournalDirName, strings.Join(s, "-"))
+}
+
+// GetConfigHash returns a hash identifying the network configuration, CNI_ARGS and network namespace
+// of an ADD invocation
+func GetConfigHash(args *skel.CmdArgs) string {
+	h := sha256.New()
+	for _, field := range []string{string(args.StdinData), args.Args, args.Netns} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RemoveAttachmentFiles removes the device-info file and the CDI spec of a cached attachment. Their paths are
+// resolved under root, the host root filesystem mount point when not run on the host.
+func RemoveAttachmentFiles(netConf *types.NetConf, root string) error {
+	var errs []error
+	if netConf.DevInfoPath != "" {
+		errs = append(errs, devinfo.Remove(filepath.Join(root, netConf.DevInfoPath)))
+	}
+	if netConf.CDISpecPath != "" {
+		errs = append(errs, cdi.Remove(filepath.Join(root, netConf.CDISpecPath)))
+	}
+	return errors.Join(errs...)
+}
+
+// IsCachedByOlderVersion returns true if the NetConf was cached by a version of the plugin which did not cache
+// the configuration hash and the result of ADD
+func IsCachedByOlderVersion(netConf *types.NetConf) bool {
+	return netConf.ConfigHash == "" && netConf.Result == nil
+}
+
+// k8sArgs holds the pod identity passed by the container runtime in CNI_ARGS
+//
+//nolint:revive,stylecheck
+type k8sArgs struct {
+	cnitypes.CommonArgs
+	K8S_POD_NAMESPACE          cnitypes.UnmarshallableString
+	K8S_POD_NAME               cnitypes.UnmarshallableString
+	K8S_POD_UID                cnitypes.UnmarshallableString
+	K8S_POD_INFRA_CONTAINER_ID cnitypes.UnmarshallableString
+}
+
+// loadK8sArgs parses the pod identity from CNI_ARGS, the fields are empty if not passed by the runtime
+func loadK8sArgs(args *skel.CmdArgs) (*k8sArgs, error) {
+	k8s := &k8sArgs{}
+	k8s.IgnoreUnknown = true
+	if err := cnitypes.LoadArgs(args.Args, k8s); err != nil {
+		return nil, fmt.Errorf("failed to parse CNI_ARGS: %v", err)
+	}
+	return k8s, nil
+}
+
+// GetCDIDeviceName returns the name of the CDI device of the attachment, <pod namespace>_<pod name>_<ifname>
+// so that it is known when the pod is created. The container ID is used if the pod identity is not passed.
+func GetCDIDeviceName(args *skel.CmdArgs) (string, error) {
+	k8s, err := loadK8sArgs(args)
+	if err != nil {
+		return "", err
+	}
+	if k8s.K8S_POD_NAMESPACE == "" || k8s.K8S_POD_NAME == "" {
+		return args.ContainerID + "_" + args.IfName, nil
+	}
+	return fmt.Sprintf("%s_%s_%s", k8s.K8S_POD_NAMESPACE, k8s.K8S_POD_NAME, args.IfName), nil
+}
+
+// NodeDescriptionData holds the fields available to the nodeDescription template
+type NodeDescriptionData struct {
+	PodNamespace string
+	PodName      string
+	PodUID       string
+	ContainerID  string
+	IfName       string
+	NetworkName  string
+}
+
+// RenderNodeDescription renders the nodeDescription template for the attachment, the result is truncated
+// to the maximal length of an InfiniBand node description
+func RenderNodeDescription(netConf *types.NetConf, args *skel.CmdArgs) (string, error) {
+	tmpl, err := template.New("nodeDescription").Parse(netConf.NodeDescription)
+	if err != nil {
+		return "", fmt.Errorf("invalid nodeDescription template: %v", err)
+	}
+
+	k8s, err := loadK8sArgs(args)
+	if err != nil {
+		return "", err
+	}
+
+	data := NodeDescriptionData{
+		PodNamespace: string(k8s.K8S_POD_NAMESPACE),
+		PodName:      string(k8s.K8S_POD_NAME),
+		PodUID:       string(k8s.K8S_POD_UID),
+		ContainerID:  args.ContainerID,
+		IfName:       args.IfName,
+		NetworkName:  netConf.Name,
+	}
+	var desc strings.Builder
+	if err = tmpl.Execute(&desc, data); err != nil {
+		return "", fmt.Errorf("failed to render nodeDescription template: %v", err)
+	}
+
+	if desc.Len() > utils.NodeDescMaxLen {
+		return strings.ToValidUTF8(desc.String()[:utils.NodeDescMaxLen], ""), nil
+	}
+	return desc.String(), nil
+}
+
 // LoadConfFromCache retrieves cached NetConf returns it along with a handle for removal
 func LoadConfFromCache(args *skel.CmdArgs) (*types.NetConf, string, error) {
-	netConf := &types.NetConf{}
+	cRefPath := GetCachedConfPath(args.ContainerID, args.IfName)
 
-	s := []string{args.ContainerID, args.IfName}
-	cRef := strings.Join(s, "-")
-	cRefPath := filepath.Join(DefaultCNIDir, cRef)
+	netConf, err := LoadConfFromCacheFile(cRefPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return netConf, cRefPath, nil
+}
+
+// LoadConfFromCacheFile retrieves cached NetConf from the given cache file
+func LoadConfFromCacheFile(cRefPath string) (*types.NetConf, error) {
+	netConf := &types.NetConf{}
 
 	netConfBytes, err := utils.ReadScratchNetConf(cRefPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("error reading cached NetConf in %s with name %s", DefaultCNIDir, cRef)
+		return nil, fmt.Errorf("error reading cached NetConf in %s with name %s",
+			filepath.Dir(cRefPath), filepath.Base(cRefPath))
 	}
 
 	if err = json.Unmarshal(netConfBytes, netConf); err != nil {
-		return nil, "", fmt.Errorf("failed to parse NetConf: %q", err)
+		return nil, fmt.Errorf("failed to parse NetConf: %q", err)
 	}
 
-	return netConf, cRefPath, nil
+	return netConf, nil
+}
+
+// ListCachedConfs returns the paths of all cached NetConf files
+func ListCachedConfs() ([]string, error) {
+	entries, err := os.ReadDir(DefaultCNIDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read NetConf cache directory %s: %v", DefaultCNIDir, err)
+	}
+
+	cRefPaths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		cRefPaths = append(cRefPaths, filepath.Join(DefaultCNIDir, entry.Name()))
+	}
+	return cRefPaths, nil
+}
+
+// ListJournals returns the paths of all journals of ADD operations which did not complete
+func ListJournals() ([]string, error) {
+	journalDir := filepath.Join(DefaultCNIDir, JournalDirName)
+	entries, err := os.ReadDir(journalDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read journal directory %s: %v", journalDir, err)
+	}
+
+	journalPaths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// skip journals being written
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) == ".tmp" {
+			continue
+		}
+		journalPaths = append(journalPaths, filepath.Join(journalDir, entry.Name()))
+	}
+	return journalPaths, nil
 }
@@ -1,8 +1,19 @@
 package config
 
 import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
 )
 
 var _ = Describe("Config", func() {
@@ -44,6 +55,524 @@ var _ = Describe("Config", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+	Context("Checking LoadConf logging configuration", func() {
+		It("Assuming valid logLevel and logFile", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "logLevel": "debug",
+        "logFile": "/var/log/ib-sriov-cni.log"
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.LogLevel).To(Equal("debug"))
+			Expect(netConf.LogFile).To(Equal("/var/log/ib-sriov-cni.log"))
+		})
+		It("Assuming invalid logLevel", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "logLevel": "verbose"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid logLevel value: verbose"))
+		})
+	})
+	Context("Checking LoadConf pkey validation", func() {
+		It("Assuming valid pkey", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "pkey": "0x8005"
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.PKey).To(Equal("0x8005"))
+		})
+		It("Assuming invalid pkey", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "pkey": "0x8000"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("invalid pkey value"))
+		})
+		It("Assuming ipoibChildPKey with pkey", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "pkey": "0x8005",
+        "ipoibChildPKey": true
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.IPoIBChildPKey).To(BeTrue())
+		})
+		It("Assuming ipoibChildPKey without pkey", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "ipoibChildPKey": true
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("ipoibChildPKey requires pkey to be set"))
+		})
+	})
+	Context("Checking LoadConf pfChildMode validation", func() {
+		It("Assuming valid pfChildMode configuration", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "master": "ib0",
+        "pkey": "0x8005",
+        "pfChildMode": true
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.PFChildMode).To(BeTrue())
+		})
+		It("Assuming pfChildMode without pkey", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "master": "ib0",
+        "pfChildMode": true
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("pfChildMode requires pkey to be set"))
+		})
+		It("Assuming pfChildMode with rdmaIsolation", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "master": "ib0",
+        "pkey": "0x8005",
+        "rdmaIsolation": true,
+        "pfChildMode": true
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming pfChildMode with cdiSpec", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "master": "ib0",
+        "pkey": "0x8005",
+        "cdiSpec": true,
+        "pfChildMode": true
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("cdiSpec"))
+		})
+	})
+	Context("Checking LoadConf IPoIB configuration", func() {
+		It("Assuming valid ipoibMode and mtu", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "ipoibMode": "connected",
+        "mtu": 65520
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.IPoIBMode).To(Equal("connected"))
+			Expect(netConf.MTU).To(Equal(65520))
+		})
+		It("Assuming invalid ipoibMode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "ipoibMode": "unreliable"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid ipoibMode value: unreliable"))
+		})
+		It("Assuming mtu not allowed in datagram mode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "ipoibMode": "datagram",
+        "mtu": 9000
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("invalid mtu value"))
+		})
+	})
+	Context("Checking RenderNodeDescription function", func() {
+		var args *skel.CmdArgs
+
+		BeforeEach(func() {
+			args = &skel.CmdArgs{
+				ContainerID: "cid",
+				IfName:      "net1",
+				Args:        "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod-0;guid=01:23:45:67:89:ab:cd:ef",
+			}
+		})
+
+		It("Assuming pod identity template", func() {
+			netConf := &types.NetConf{}
+			netConf.Name = "ibnet"
+			netConf.NodeDescription = "{{.PodNamespace}}/{{.PodName}} {{.IfName}} {{.NetworkName}}"
+			Expect(RenderNodeDescription(netConf, args)).To(Equal("default/pod-0 net1 ibnet"))
+		})
+		It("Assuming description longer than allowed", func() {
+			netConf := &types.NetConf{}
+			netConf.NodeDescription = strings.Repeat("x", 70) + "{{.PodName}}"
+			desc, err := RenderNodeDescription(netConf, args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(desc).To(HaveLen(64))
+		})
+		It("Assuming template with unknown field", func() {
+			netConf := &types.NetConf{}
+			netConf.NodeDescription = "{{.Pod}}"
+			_, err := RenderNodeDescription(netConf, args)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming invalid template in configuration", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "nodeDescription": "{{.PodName"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("invalid nodeDescription template"))
+		})
+	})
+	Context("Checking guidPool configuration", func() {
+		It("Assuming valid guidPool", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "guidPool": ["02:00:00:00:00:00:00:01-02:00:00:00:00:00:00:ff"]
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.GUIDPool).To(Equal([]string{"02:00:00:00:00:00:00:01-02:00:00:00:00:00:00:ff"}))
+		})
+		It("Assuming invalid guidPool entry", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "guidPool": ["02:00:00:00:00:00:01"]
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("invalid guidPool entry"))
+		})
+		It("Assuming guidPool with ibKubernetesEnabled", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "ibKubernetesEnabled": true,
+        "guidPool": ["02:00:00:00:00:00:00:01"]
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking guidMode configuration", func() {
+		var args *skel.CmdArgs
+
+		BeforeEach(func() {
+			args = &skel.CmdArgs{ContainerID: "cid", IfName: "net1",
+				Args: "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod-0"}
+		})
+
+		It("Assuming invalid guidMode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "guidMode": "random"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid guidMode value: random"))
+		})
+		It("Assuming invalid guidPrefix", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "guidMode": "derived",
+        "guidPrefix": "02:00:00:00:00:00:00:00"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("invalid guidPrefix value"))
+		})
+		It("Assuming derived guidMode with guidPool", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "guidMode": "derived",
+        "guidPool": ["02:00:00:00:00:00:00:01"]
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming GUID derived from the pod identity", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "guidMode": "derived",
+        "guidPrefix": "02:c9:03"
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			guid, err := DeriveGUID(netConf, args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(guid).To(HavePrefix("02:c9:03:"))
+
+			// the GUID is stable for the same pod identity and differs for other pods
+			again, err := DeriveGUID(netConf, args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).To(Equal(guid))
+			args.Args = "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod-1"
+			other, err := DeriveGUID(netConf, args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(other).NotTo(Equal(guid))
+		})
+		It("Assuming derived GUID without pod identity", func() {
+			netConf := &types.NetConf{IbSriovNetConf: types.IbSriovNetConf{GUIDMode: GUIDModeDerived}}
+			netConf.Name = "mynet"
+			_, err := DeriveGUID(netConf, &skel.CmdArgs{ContainerID: "cid", IfName: "net1"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking nodeGUID and portGUID configuration", func() {
+		It("Assuming valid node and port GUIDs", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "nodeGUID": "02:00:00:00:00:00:10:01",
+        "portGUID": "02:00:00:00:00:00:20:01"
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.GetNodeGUID()).To(Equal("02:00:00:00:00:00:10:01"))
+			Expect(netConf.GetPortGUID()).To(Equal("02:00:00:00:00:00:20:01"))
+		})
+		It("Assuming invalid portGUID", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "portGUID": "00:00:00:00:00:00:00:00"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid portGUID value: 00:00:00:00:00:00:00:00"))
+		})
+		It("Assuming nodeGUID with pfChildMode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "master": "ib0",
+        "pfChildMode": true,
+        "nodeGUID": "02:00:00:00:00:00:10:01"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking CDI configuration", func() {
+		It("Assuming CDI device name from pod identity", func() {
+			args := &skel.CmdArgs{ContainerID: "cid", IfName: "net1",
+				Args: "IgnoreUnknown=1;K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod-0"}
+			Expect(GetCDIDeviceName(args)).To(Equal("default_pod-0_net1"))
+		})
+		It("Assuming CDI device name without pod identity", func() {
+			args := &skel.CmdArgs{ContainerID: "cid", IfName: "net1"}
+			Expect(GetCDIDeviceName(args)).To(Equal("cid_net1"))
+		})
+		It("Assuming cdiSpec with vfioPciMode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "vfioPciMode": true,
+        "cdiSpec": true
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("cdiSpec is not supported with vfioPciMode"))
+		})
+	})
+	Context("Checking LoadConf lock configuration", func() {
+		It("Assuming valid lockScope and lockTimeout", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "lockScope": "pf",
+        "lockTimeout": 30
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.LockScope).To(Equal(LockScopePF))
+			Expect(GetLockTimeout(netConf)).To(Equal(30 * time.Second))
+		})
+		It("Assuming invalid lockScope", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "lockScope": "node"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid lockScope value: node"))
+		})
+		It("Assuming negative lockTimeout", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "lockTimeout": -1
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid lockTimeout value: -1"))
+		})
+	})
+	Context("Checking LoadConf waitForPortActive configuration", func() {
+		It("Assuming valid waitForPortActive and waitForPhysLinkUp", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "waitForPortActive": 30,
+        "waitForPhysLinkUp": true,
+        "lockScope": "vf"
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.WaitForPortActive).To(Equal(30))
+			Expect(netConf.WaitForPhysLinkUp).To(BeTrue())
+		})
+		It("Assuming negative waitForPortActive", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "waitForPortActive": -1
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid waitForPortActive value: -1"))
+		})
+		It("Assuming waitForPhysLinkUp without waitForPortActive", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "waitForPhysLinkUp": true
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("waitForPhysLinkUp requires waitForPortActive to be set"))
+		})
+		It("Assuming waitForPortActive with the default global lockScope", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "waitForPortActive": 30
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("waitForPortActive requires lockScope pf or vf"))
+		})
+		It("Assuming waitForPortActive with vfioPciMode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov",
+        "deviceID": "0000:af:06.1",
+        "vfioPciMode": true,
+        "waitForPortActive": 30
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking lock functions", func() {
+		It("Assuming default lock configuration", func() {
+			netConf := &types.NetConf{IbSriovNetConf: types.IbSriovNetConf{Master: "ib0", DeviceID: "0000:af:06.0"}}
+			Expect(GetLockFilePath(netConf)).To(Equal(filepath.Join(CniFileLockDir, CniFileLockName)))
+			Expect(GetLockTimeout(netConf)).To(Equal(DefaultLockTimeout))
+		})
+		It("Assuming pf lockScope", func() {
+			netConf := &types.NetConf{IbSriovNetConf: types.IbSriovNetConf{Master: "ib0", DeviceID: "0000:af:06.0", LockScope: LockScopePF}}
+			Expect(GetLockFilePath(netConf)).To(Equal(filepath.Join(CniFileLockDir, "pf-ib0.lock")))
+		})
+		It("Assuming pf lockScope with PF passthrough device", func() {
+			netConf := &types.NetConf{IbSriovNetConf: types.IbSriovNetConf{DeviceID: "0000:af:00.0", LockScope: LockScopePF}}
+			Expect(GetLockFilePath(netConf)).To(Equal(filepath.Join(CniFileLockDir, "pf-0000:af:00.0.lock")))
+		})
+		It("Assuming vf lockScope", func() {
+			netConf := &types.NetConf{IbSriovNetConf: types.IbSriovNetConf{Master: "ib0", DeviceID: "0000:af:06.0", LockScope: LockScopeVF}}
+			Expect(GetLockFilePath(netConf)).To(Equal(filepath.Join(CniFileLockDir, "vf-0000:af:06.0.lock")))
+		})
+		It("Assuming vf lockScope without device", func() {
+			netConf := &types.NetConf{IbSriovNetConf: types.IbSriovNetConf{LockScope: LockScopeVF}}
+			Expect(GetLockFilePath(netConf)).To(Equal(GetGlobalLockFilePath()))
+		})
+	})
+	Context("Checking GetConfigHash function", func() {
+		args := &skel.CmdArgs{
+			ContainerID: "cid",
+			Netns:       "/var/run/netns/test",
+			IfName:      "net1",
+			Args:        "guid=00:00:00:00:00:00:00:01",
+			StdinData:   []byte(`{"name": "mynet", "type": "ib-sriov"}`),
+		}
+		It("Assuming identical invocations", func() {
+			repeated := *args
+			Expect(GetConfigHash(&repeated)).To(Equal(GetConfigHash(args)))
+		})
+		It("Assuming different CNI_ARGS", func() {
+			changed := *args
+			changed.Args = "guid=00:00:00:00:00:00:00:02"
+			Expect(GetConfigHash(&changed)).NotTo(Equal(GetConfigHash(args)))
+		})
+		It("Assuming different network namespace", func() {
+			changed := *args
+			changed.Netns = "/var/run/netns/other"
+			Expect(GetConfigHash(&changed)).NotTo(Equal(GetConfigHash(args)))
+		})
+		It("Assuming different network configuration", func() {
+			changed := *args
+			changed.StdinData = []byte(`{"name": "mynet", "type": "ib-sriov", "pkey": "0x1"}`)
+			Expect(GetConfigHash(&changed)).NotTo(Equal(GetConfigHash(args)))
+		})
+	})
 	Context("Checking getVfInfo function", func() {
 		It("Assuming existing PF", func() {
 			_, _, err := getVfInfo("0000:af:06.0")
@@ -54,4 +583,175 @@ var _ = Describe("Config", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+	Context("Checking LoadVFInfo function", func() {
+		It("Assuming existing VF", func() {
+			netConf := &types.NetConf{}
+			netConf.DeviceID = "0000:af:06.1"
+			Expect(LoadVFInfo(netConf)).To(Succeed())
+			Expect(netConf.Master).To(Equal("ib0"))
+			Expect(netConf.VFID).To(Equal(1))
+		})
+		It("Assuming master given and not existing VF", func() {
+			netConf := &types.NetConf{}
+			netConf.Master = "ib0"
+			netConf.DeviceID = "0000:af:07.0"
+			Expect(LoadVFInfo(netConf)).NotTo(Succeed())
+		})
+		It("Assuming no deviceID", func() {
+			Expect(LoadVFInfo(&types.NetConf{})).NotTo(Succeed())
+		})
+	})
+	Context("Checking LoadPFInfo function", func() {
+		It("Assuming PF given by deviceID", func() {
+			netConf := &types.NetConf{}
+			netConf.DeviceID = "0000:af:00.1"
+			Expect(LoadPFInfo(netConf)).To(Succeed())
+			Expect(netConf.Master).To(Equal("ib0"))
+		})
+		It("Assuming PF given by master", func() {
+			netConf := &types.NetConf{}
+			netConf.Master = "ib0"
+			Expect(LoadPFInfo(netConf)).To(Succeed())
+			Expect(netConf.DeviceID).To(Equal("0000:af:00.1"))
+		})
+		It("Assuming neither master nor deviceID", func() {
+			Expect(LoadPFInfo(&types.NetConf{})).NotTo(Succeed())
+		})
+		It("Assuming not existing master", func() {
+			netConf := &types.NetConf{}
+			netConf.Master = "ib9"
+			Expect(LoadPFInfo(netConf)).NotTo(Succeed())
+		})
+	})
+	Context("Checking NetConf cache functions", func() {
+		var (
+			origCNIDir string
+			tmpDir     string
+		)
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = os.MkdirTemp("", "ib-sriov-cni-cache-")
+			Expect(err).NotTo(HaveOccurred())
+			origCNIDir = DefaultCNIDir
+			DefaultCNIDir = tmpDir
+		})
+		AfterEach(func() {
+			DefaultCNIDir = origCNIDir
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+
+		It("Assuming cached NetConf saved by utils.SaveNetConf", func() {
+			netConf := &types.NetConf{}
+			netConf.Name = "mynet"
+			netConf.DeviceID = "0000:af:06.0"
+			netConf.NetnsPath = "/var/run/netns/test"
+			Expect(utils.SaveNetConf("cid", DefaultCNIDir, "net1", netConf)).To(Succeed())
+
+			cRefPath := GetCachedConfPath("cid", "net1")
+			Expect(cRefPath).To(Equal(filepath.Join(tmpDir, "cid-net1")))
+
+			cachedConf, err := LoadConfFromCacheFile(cRefPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cachedConf.Name).To(Equal("mynet"))
+			Expect(cachedConf.DeviceID).To(Equal("0000:af:06.0"))
+			Expect(cachedConf.NetnsPath).To(Equal("/var/run/netns/test"))
+
+			cachedConf, loadedPath, err := LoadConfFromCache(&skel.CmdArgs{ContainerID: "cid", IfName: "net1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loadedPath).To(Equal(cRefPath))
+			Expect(cachedConf.Name).To(Equal("mynet"))
+		})
+		It("Assuming cached NetConf with the GUIDs set during ADD", func() {
+			netConf := &types.NetConf{}
+			netConf.DeviceID = "0000:af:06.0"
+			// the requested GUID is not cached, the GUIDs set on the VF are
+			netConf.GUID = "02:00:00:00:00:00:00:01"
+			netConf.AppliedNodeGUID = "02:00:00:00:00:00:10:01"
+			netConf.AppliedPortGUID = "02:00:00:00:00:00:00:01"
+			Expect(utils.SaveNetConf("cid", DefaultCNIDir, "net1", netConf)).To(Succeed())
+
+			cachedConf, _, err := LoadConfFromCache(&skel.CmdArgs{ContainerID: "cid", IfName: "net1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cachedConf.GUID).To(BeEmpty())
+			Expect(cachedConf.AppliedNodeGUID).To(Equal("02:00:00:00:00:00:10:01"))
+			Expect(cachedConf.AppliedPortGUID).To(Equal("02:00:00:00:00:00:00:01"))
+		})
+		It("Assuming cached NetConf with ADD result", func() {
+			netConf := &types.NetConf{}
+			netConf.DeviceID = "0000:af:06.0"
+			netConf.ConfigHash = "hash"
+			netConf.Result = &current.Result{
+				CNIVersion: "1.0.0",
+				Interfaces: []*current.Interface{{Name: "net1", Sandbox: "/var/run/netns/test"}},
+				IPs: []*current.IPConfig{{
+					Interface: current.Int(0),
+					Address:   net.IPNet{IP: net.ParseIP("192.168.1.2"), Mask: net.CIDRMask(24, 32)},
+				}},
+			}
+			Expect(utils.SaveNetConf("cid", DefaultCNIDir, "net1", netConf)).To(Succeed())
+
+			cachedConf, err := LoadConfFromCacheFile(GetCachedConfPath("cid", "net1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cachedConf.ConfigHash).To(Equal("hash"))
+			Expect(cachedConf.Result).To(Equal(netConf.Result))
+		})
+		It("Assuming cached NetConf with ADD result is not cached by an older version", func() {
+			netConf := &types.NetConf{}
+			netConf.ConfigHash = "hash"
+			netConf.Result = &current.Result{CNIVersion: "1.0.0"}
+			Expect(IsCachedByOlderVersion(netConf)).To(BeFalse())
+		})
+		It("Assuming NetConf cached by an older version", func() {
+			// cached by the plugin before the configuration hash and the result of ADD were cached
+			legacyConf := `{"cniVersion":"0.3.1","name":"mynet","type":"ib-sriov","ipam":{"type":"host-local"},` +
+				`"dns":{},"Master":"ib0","deviceID":"0000:af:06.0","VFID":0,"HostIFNames":"ib1",` +
+				`"HostIFGUID":"00:00:00:00:00:00:00:00","ContIFNames":"net1","pkey":"0x8005",` +
+				`"RdmaNetState":{"version":"","deviceID":"","sandboxRdmaDevName":"","containerRdmaDevName":""},` +
+				`"runtimeConfig":{"infinibandGUID":"02:00:00:00:00:00:00:01"},"args":{"cni":null}}`
+			Expect(os.WriteFile(GetCachedConfPath("cid", "net1"), []byte(legacyConf), 0600)).To(Succeed())
+
+			cachedConf, _, err := LoadConfFromCache(&skel.CmdArgs{ContainerID: "cid", IfName: "net1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cachedConf.DeviceID).To(Equal("0000:af:06.0"))
+			Expect(IsCachedByOlderVersion(cachedConf)).To(BeTrue())
+		})
+		It("Assuming missing cached NetConf", func() {
+			_, err := LoadConfFromCacheFile(GetCachedConfPath("cid", "net1"))
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming cache directory with files and directories", func() {
+			Expect(os.WriteFile(filepath.Join(tmpDir, "cid1-net1"), []byte("{}"), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(tmpDir, "cid2-net1"), []byte("{}"), 0600)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(tmpDir, "subdir"), 0700)).To(Succeed())
+
+			cRefPaths, err := ListCachedConfs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cRefPaths).To(ConsistOf(filepath.Join(tmpDir, "cid1-net1"), filepath.Join(tmpDir, "cid2-net1")))
+		})
+		It("Assuming journal directory with journals", func() {
+			journalDir := filepath.Join(tmpDir, JournalDirName)
+			Expect(os.Mkdir(journalDir, 0700)).To(Succeed())
+			Expect(os.WriteFile(GetJournalPath("cid1", "net1"), []byte("{}"), 0600)).To(Succeed())
+			Expect(os.WriteFile(GetJournalPath("cid2", "net1")+".tmp", []byte("{}"), 0600)).To(Succeed())
+
+			journalPaths, err := ListJournals()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(journalPaths).To(ConsistOf(filepath.Join(journalDir, "cid1-net1")))
+
+			cRefPaths, err := ListCachedConfs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cRefPaths).To(BeEmpty())
+		})
+		It("Assuming missing cache directory", func() {
+			DefaultCNIDir = filepath.Join(tmpDir, "missing")
+			cRefPaths, err := ListCachedConfs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cRefPaths).To(BeEmpty())
+
+			journalPaths, err := ListJournals()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(journalPaths).To(BeEmpty())
+		})
+	})
 })
@@ -0,0 +1,90 @@
+// Package devinfo writes the device-info file of an attachment as defined by the k8snetworkplumbingwg
+// device-info specification, so that Multus can report the device in the network-status annotation.
+package devinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
+)
+
+const (
+	// SpecVersion is the version of the device-info specification
+	SpecVersion = "1.1.0"
+	// TypePci is the device-info type of PCI devices
+	TypePci = "pci"
+)
+
+// Metadata keys of the RDMA details of the device
+const (
+	MetadataRdmaUverbs   = "rdma-uverbs"
+	MetadataRdmaPortGUID = "rdma-port-guid"
+	MetadataRdmaLID      = "rdma-lid"
+	MetadataCDIDevice    = "cdi-device"
+)
+
+// DevInfoDir is the directory the device-info files written by CNI plugins are read from
+var DevInfoDir = "/var/run/k8s.cni.cncf.io/devinfo/cni"
+
+// PciDevice holds the PCI details of the device
+type PciDevice struct {
+	PciAddress   string `json:"pci-address,omitempty"`
+	RdmaDevice   string `json:"rdma-device,omitempty"`
+	PfPciAddress string `json:"pf-pci-address,omitempty"`
+}
+
+// DeviceInfo is the content of a device-info file
+type DeviceInfo struct {
+	Type     string            `json:"type"`
+	Version  string            `json:"version"`
+	Pci      *PciDevice        `json:"pci,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// NewPciDeviceInfo returns the device-info of a PCI device
+func NewPciDeviceInfo(pci *PciDevice) *DeviceInfo {
+	return &DeviceInfo{
+		Type:    TypePci,
+		Version: SpecVersion,
+		Pci:     pci,
+	}
+}
+
+// GetPath returns the path of the device-info file of the attachment of network cniName
+func GetPath(cniName, containerID, ifName string) string {
+	return filepath.Join(DevInfoDir, fmt.Sprintf("%s-%s-%s-device-info.json", cniName, containerID, ifName))
+}
+
+// Save writes the device-info file of the attachment
+func Save(cniName, containerID, ifName string, info *DeviceInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to serialize device-info: %v", err)
+	}
+
+	if err = os.MkdirAll(DevInfoDir, utils.OwnerReadWriteExecuteOthersReadExecuteAttrs); err != nil {
+		return fmt.Errorf("failed to create device-info directory %s: %v", DevInfoDir, err)
+	}
+
+	path := GetPath(cniName, containerID, ifName)
+	if err = os.WriteFile(path, data, utils.OwnerReadWriteOthersReadAttrs); err != nil {
+		return fmt.Errorf("failed to write device-info file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Clean deletes the device-info file of the attachment if it exists
+func Clean(cniName, containerID, ifName string) error {
+	return Remove(GetPath(cniName, containerID, ifName))
+}
+
+// Remove deletes the device-info file at path if it exists
+func Remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove device-info file %s: %v", path, err)
+	}
+	return nil
+}
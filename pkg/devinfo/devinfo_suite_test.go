@@ -0,0 +1,13 @@
+package devinfo
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDevInfo(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DevInfo Suite")
+}
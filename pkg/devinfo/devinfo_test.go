@@ -0,0 +1,66 @@
+package devinfo
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DevInfo", func() {
+	var (
+		tmpDir         string
+		origDevInfoDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ib-sriov-cni-devinfo-")
+		Expect(err).NotTo(HaveOccurred())
+		origDevInfoDir = DevInfoDir
+		DevInfoDir = filepath.Join(tmpDir, "devinfo", "cni")
+	})
+	AfterEach(func() {
+		DevInfoDir = origDevInfoDir
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	Context("Checking device-info files", func() {
+		It("Assuming device-info saved", func() {
+			info := NewPciDeviceInfo(&PciDevice{
+				PciAddress:   "0000:af:06.0",
+				RdmaDevice:   "mlx5_2",
+				PfPciAddress: "0000:af:00.1",
+			})
+			info.Metadata = map[string]string{MetadataRdmaUverbs: "/dev/infiniband/uverbs2"}
+			Expect(Save("mynet", "container", "net1", info)).To(Succeed())
+
+			path := GetPath("mynet", "container", "net1")
+			Expect(path).To(Equal(filepath.Join(DevInfoDir, "mynet-container-net1-device-info.json")))
+			data, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(MatchJSON(`{
+				"type": "pci",
+				"version": "1.1.0",
+				"pci": {"pci-address": "0000:af:06.0", "rdma-device": "mlx5_2", "pf-pci-address": "0000:af:00.1"},
+				"metadata": {"rdma-uverbs": "/dev/infiniband/uverbs2"}
+			}`))
+
+			loaded := &DeviceInfo{}
+			Expect(json.Unmarshal(data, loaded)).To(Succeed())
+			Expect(loaded).To(Equal(info))
+		})
+		It("Assuming device-info cleaned", func() {
+			Expect(Save("mynet", "container", "net1", NewPciDeviceInfo(&PciDevice{PciAddress: "0000:af:06.0"}))).To(Succeed())
+			Expect(Clean("mynet", "container", "net1")).To(Succeed())
+			_, err := os.Stat(GetPath("mynet", "container", "net1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("Assuming missing device-info cleaned", func() {
+			Expect(Clean("mynet", "container", "net1")).To(Succeed())
+			Expect(Remove(filepath.Join(DevInfoDir, "missing"))).To(Succeed())
+		})
+	})
+})
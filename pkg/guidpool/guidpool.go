@@ -0,0 +1,251 @@
+// Package guidpool allocates VF GUIDs from the guidPool of the network configuration. Allocations of all networks
+// are kept in a single node-local store so that a GUID is never given to two attachments.
+package guidpool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
+)
+
+const (
+	storeFileName = "allocations.json"
+	lockFileName  = "allocations.lock"
+)
+
+// Allocation is a GUID given to an attachment
+type Allocation struct {
+	GUID    string `json:"guid"`
+	Owner   string `json:"owner"` // <container id>-<ifname> of the attachment
+	Network string `json:"network"`
+}
+
+// guidRange is an inclusive range of GUIDs
+type guidRange struct {
+	first uint64
+	last  uint64
+}
+
+// Pool allocates the GUIDs of a guidPool
+type Pool struct {
+	dir         string
+	ranges      []guidRange
+	lockTimeout time.Duration
+}
+
+// Validate checks the guidPool entries, each a GUID or an inclusive range "<first GUID>-<last GUID>"
+func Validate(entries []string) error {
+	_, err := parseRanges(entries)
+	return err
+}
+
+// New returns the pool of the guidPool entries, allocations are stored in dir. The store lock is waited for up
+// to lockTimeout.
+func New(dir string, entries []string, lockTimeout time.Duration) (*Pool, error) {
+	ranges, err := parseRanges(entries)
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{dir: dir, ranges: ranges, lockTimeout: lockTimeout}, nil
+}
+
+// Allocate gives a free GUID of the pool to owner. The GUID already allocated to owner is returned if any,
+// e.g. when an interrupted ADD is retried.
+func (p *Pool) Allocate(owner, network string) (string, error) {
+	var guid string
+	err := update(p.dir, p.lockTimeout, func(allocs []Allocation) ([]Allocation, error) {
+		allocated := make(map[uint64]bool, len(allocs))
+		for _, alloc := range allocs {
+			if alloc.Owner == owner {
+				guid = alloc.GUID
+				return allocs, nil
+			}
+			value, err := parseGUID(alloc.GUID)
+			if err != nil {
+				return nil, err
+			}
+			allocated[value] = true
+		}
+
+		for _, r := range p.ranges {
+			for value := r.first; ; value++ {
+				if !allocated[value] && isAssignable(value) {
+					guid = formatGUID(value)
+					return append(allocs, Allocation{GUID: guid, Owner: owner, Network: network}), nil
+				}
+				if value == r.last {
+					break
+				}
+			}
+		}
+		return nil, fmt.Errorf("no free GUID left in guidPool of network %s", network)
+	})
+	if err != nil {
+		return "", err
+	}
+	return guid, nil
+}
+
+// Release returns the GUID allocated to owner to its pool, it does nothing if owner has no GUID allocated
+func Release(dir, owner string, lockTimeout time.Duration) error {
+	return update(dir, lockTimeout, func(allocs []Allocation) ([]Allocation, error) {
+		kept := allocs[:0]
+		for _, alloc := range allocs {
+			if alloc.Owner != owner {
+				kept = append(kept, alloc)
+			}
+		}
+		return kept, nil
+	})
+}
+
+// Lookup returns the GUID allocated to owner, or an empty string if owner has no GUID allocated
+func Lookup(dir, owner string, lockTimeout time.Duration) (string, error) {
+	var guid string
+	err := withLock(dir, lockTimeout, func() error {
+		allocs, err := load(dir)
+		if err != nil {
+			return err
+		}
+		for _, alloc := range allocs {
+			if alloc.Owner == owner {
+				guid = alloc.GUID
+				break
+			}
+		}
+		return nil
+	})
+	return guid, err
+}
+
+// update applies change to the allocations while holding the store lock and saves the result
+func update(dir string, lockTimeout time.Duration, change func([]Allocation) ([]Allocation, error)) error {
+	return withLock(dir, lockTimeout, func() error {
+		allocs, err := load(dir)
+		if err != nil {
+			return err
+		}
+		updated, err := change(allocs)
+		if err != nil {
+			return err
+		}
+		return save(dir, updated)
+	})
+}
+
+// withLock runs fn while holding the lock of the store, serializing allocations of all CNI invocations on the node.
+// It fails if the lock is not acquired within lockTimeout.
+func withLock(dir string, lockTimeout time.Duration, fn func() error) error {
+	if err := os.MkdirAll(dir, utils.OwnerReadWriteExecuteAttrs); err != nil {
+		return fmt.Errorf("failed to create GUID pool directory %s: %v", dir, err)
+	}
+
+	lockFile := filepath.Join(dir, lockFileName)
+	lock, err := utils.LockFile(lockFile, lockTimeout)
+	if errors.Is(err, utils.ErrLockTimeout) {
+		return fmt.Errorf("timed out after %s waiting for GUID pool store lock %s", lockTimeout, lockFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock GUID pool store: %v", err)
+	}
+	defer func() { _ = lock.Unlock() }()
+	return fn()
+}
+
+// load reads the allocations and verifies that no GUID is allocated twice
+func load(dir string) ([]Allocation, error) {
+	path := filepath.Join(dir, storeFileName)
+	data, err := os.ReadFile(path) /* #nosec G304 */
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Allocation{}, nil
+		}
+		return nil, fmt.Errorf("failed to read GUID pool store %s: %v", path, err)
+	}
+
+	allocs := []Allocation{}
+	if err = json.Unmarshal(data, &allocs); err != nil {
+		return nil, fmt.Errorf("failed to parse GUID pool store %s: %v", path, err)
+	}
+
+	owners := make(map[string]string, len(allocs))
+	for _, alloc := range allocs {
+		guid := strings.ToLower(alloc.GUID)
+		if owner, found := owners[guid]; found {
+			return nil, fmt.Errorf("GUID %s is allocated to both %s and %s", alloc.GUID, owner, alloc.Owner)
+		}
+		owners[guid] = alloc.Owner
+	}
+	return allocs, nil
+}
+
+func save(dir string, allocs []Allocation) error {
+	data, err := json.Marshal(allocs)
+	if err != nil {
+		return fmt.Errorf("failed to serialize GUID pool store: %v", err)
+	}
+
+	// write to a temporary file first so that an interrupted write never loses the allocations
+	path := filepath.Join(dir, storeFileName)
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, data, utils.OwnerReadWriteAttrs); err != nil {
+		return fmt.Errorf("failed to write GUID pool store %s: %v", tmpPath, err)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write GUID pool store %s: %v", path, err)
+	}
+	return nil
+}
+
+func parseRanges(entries []string) ([]guidRange, error) {
+	ranges := make([]guidRange, 0, len(entries))
+	for _, entry := range entries {
+		firstGUID, lastGUID, isRange := strings.Cut(entry, "-")
+		if !isRange {
+			lastGUID = firstGUID
+		}
+		first, err := parseGUID(strings.TrimSpace(firstGUID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid guidPool entry %q: %v", entry, err)
+		}
+		last, err := parseGUID(strings.TrimSpace(lastGUID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid guidPool entry %q: %v", entry, err)
+		}
+		if first > last {
+			return nil, fmt.Errorf("invalid guidPool entry %q: first GUID is greater than last GUID", entry)
+		}
+		ranges = append(ranges, guidRange{first: first, last: last})
+	}
+	return ranges, nil
+}
+
+func parseGUID(guid string) (uint64, error) {
+	if !utils.IsValidGUID(guid) {
+		return 0, fmt.Errorf("invalid GUID %s", guid)
+	}
+	hwAddr, err := net.ParseMAC(guid)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GUID %s: %v", guid, err)
+	}
+	return binary.BigEndian.Uint64(hwAddr), nil
+}
+
+func formatGUID(value uint64) string {
+	hwAddr := make(net.HardwareAddr, 8)
+	binary.BigEndian.PutUint64(hwAddr, value)
+	return hwAddr.String()
+}
+
+// isAssignable excludes the all zeros and all ones GUIDs, which reset the VF GUID
+func isAssignable(value uint64) bool {
+	return value != 0 && value != ^uint64(0)
+}
@@ -0,0 +1,13 @@
+package guidpool
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestGUIDPool(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "GUID Pool Suite")
+}
@@ -0,0 +1,106 @@
+package guidpool
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const lockTimeout = 5 * time.Second
+
+var _ = Describe("GUID pool", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ib-sriov-cni-guidpool-")
+		Expect(err).NotTo(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	Context("Checking guidPool validation", func() {
+		It("Assuming valid GUIDs and ranges", func() {
+			Expect(Validate([]string{"02:00:00:00:00:00:00:01", "02:00:00:00:00:00:01:00-02:00:00:00:00:00:01:ff"})).To(Succeed())
+		})
+		It("Assuming invalid GUID", func() {
+			Expect(Validate([]string{"02:00:00:00:00:00:01"})).NotTo(Succeed())
+			Expect(Validate([]string{"00:00:00:00:00:00:00:00"})).NotTo(Succeed())
+		})
+		It("Assuming reversed range", func() {
+			err := Validate([]string{"02:00:00:00:00:00:00:ff-02:00:00:00:00:00:00:01"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("first GUID is greater than last GUID"))
+		})
+	})
+
+	Context("Checking GUID allocation", func() {
+		It("Assuming GUIDs allocated and released", func() {
+			pool, err := New(tmpDir, []string{"02:00:00:00:00:00:00:01-02:00:00:00:00:00:00:02", "02:00:00:00:00:00:01:00"}, lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(pool.Allocate("cid1-net1", "ibnet")).To(Equal("02:00:00:00:00:00:00:01"))
+			Expect(pool.Allocate("cid2-net1", "ibnet")).To(Equal("02:00:00:00:00:00:00:02"))
+			Expect(pool.Allocate("cid3-net1", "ibnet")).To(Equal("02:00:00:00:00:00:01:00"))
+			_, err = pool.Allocate("cid4-net1", "ibnet")
+			Expect(err).To(HaveOccurred())
+
+			Expect(Release(tmpDir, "cid2-net1", lockTimeout)).To(Succeed())
+			Expect(Lookup(tmpDir, "cid2-net1", lockTimeout)).To(BeEmpty())
+			Expect(pool.Allocate("cid4-net1", "ibnet")).To(Equal("02:00:00:00:00:00:00:02"))
+		})
+		It("Assuming GUID already allocated to the owner", func() {
+			pool, err := New(tmpDir, []string{"02:00:00:00:00:00:00:01-02:00:00:00:00:00:00:ff"}, lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pool.Allocate("cid1-net1", "ibnet")).To(Equal("02:00:00:00:00:00:00:01"))
+			Expect(pool.Allocate("cid1-net1", "ibnet")).To(Equal("02:00:00:00:00:00:00:01"))
+			Expect(Lookup(tmpDir, "cid1-net1", lockTimeout)).To(Equal("02:00:00:00:00:00:00:01"))
+		})
+		It("Assuming allocations of another network and plugin restart", func() {
+			pool, err := New(tmpDir, []string{"02:00:00:00:00:00:00:01-02:00:00:00:00:00:00:ff"}, lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pool.Allocate("cid1-net1", "ibnet")).To(Equal("02:00:00:00:00:00:00:01"))
+
+			other, err := New(tmpDir, []string{"02:00:00:00:00:00:00:01-02:00:00:00:00:00:00:02"}, lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(other.Allocate("cid1-net2", "ibnet2")).To(Equal("02:00:00:00:00:00:00:02"))
+		})
+		It("Assuming all ones GUID in range", func() {
+			pool, err := New(tmpDir, []string{"ff:ff:ff:ff:ff:ff:ff:fe-ff:ff:ff:ff:ff:ff:ff:ff"}, lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pool.Allocate("cid1-net1", "ibnet")).To(Equal("ff:ff:ff:ff:ff:ff:ff:fe"))
+			_, err = pool.Allocate("cid2-net1", "ibnet")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming GUID allocated twice in the store", func() {
+			Expect(os.WriteFile(filepath.Join(tmpDir, storeFileName), []byte(`[
+				{"guid": "02:00:00:00:00:00:00:01", "owner": "cid1-net1", "network": "ibnet"},
+				{"guid": "02:00:00:00:00:00:00:01", "owner": "cid2-net1", "network": "ibnet"}]`), 0o600)).To(Succeed())
+
+			pool, err := New(tmpDir, []string{"02:00:00:00:00:00:00:01-02:00:00:00:00:00:00:ff"}, lockTimeout)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = pool.Allocate("cid3-net1", "ibnet")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("GUID 02:00:00:00:00:00:00:01 is allocated to both cid1-net1 and cid2-net1"))
+		})
+		It("Assuming release without allocation", func() {
+			Expect(Release(tmpDir, "cid1-net1", lockTimeout)).To(Succeed())
+		})
+		It("Assuming store lock held by another invocation", func() {
+			lock := flock.New(filepath.Join(tmpDir, lockFileName))
+			Expect(lock.Lock()).To(Succeed())
+			defer func() { _ = lock.Unlock() }()
+
+			pool, err := New(tmpDir, []string{"02:00:00:00:00:00:00:01"}, 0)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = pool.Allocate("cid1-net1", "ibnet")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("timed out after 0s waiting for GUID pool store lock"))
+		})
+	})
+})
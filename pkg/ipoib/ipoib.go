@@ -0,0 +1,181 @@
+// Package ipoib attaches pods to an InfiniBand partition through an IPoIB child interface of a PF,
+// for HCAs without SR-IOV enabled.
+package ipoib
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/logging"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/sriov"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
+)
+
+const ipoibLinkType = "ipoib"
+
+type ipoibManager struct {
+	nLink types.NetlinkManager
+}
+
+// NewIPoIBManager returns an instance of Manager creating IPoIB child interfaces of the PF
+func NewIPoIBManager() types.Manager {
+	return &ipoibManager{
+		nLink: &sriov.MyNetlink{},
+	}
+}
+
+// ApplyVFConfig verifies the PF exists, the PF itself is not configured
+func (m *ipoibManager) ApplyVFConfig(conf *types.NetConf) error {
+	if _, err := m.nLink.LinkByName(conf.Master); err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+	return nil
+}
+
+// SetupVF creates the IPoIB child of the pkey on top of the PF and moves it to Pod netns
+func (m *ipoibManager) SetupVF(conf *types.NetConf, podifName, cid string, netns ns.NetNS) error {
+	pfLink, err := m.nLink.LinkByName(conf.Master)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+
+	pkey, err := utils.ParsePKey(conf.PKey)
+	if err != nil {
+		return fmt.Errorf("invalid pkey %q: %v", conf.PKey, err)
+	}
+
+	if conf.MTU != 0 {
+		mode := conf.IPoIBMode
+		if mode == "" {
+			mode = utils.IPoIBModeDatagram
+		}
+		if err = utils.ValidateIPoIBMTU(mode, conf.MTU); err != nil {
+			return fmt.Errorf("invalid mtu for IPoIB child of %s: %v", conf.Master, err)
+		}
+	}
+
+	// tempName used as intermediary name to avoid name conflicts
+	tempName, err := ip.RandomVethName()
+	if err != nil {
+		return err
+	}
+
+	child := &netlink.IPoIB{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        tempName,
+			ParentIndex: pfLink.Attrs().Index,
+			MTU:         conf.MTU,
+		},
+		Pkey: pkey,
+		// datagram mode if no mode is configured
+		Mode: netlink.StringToIPoIBMode[conf.IPoIBMode],
+	}
+	if err = m.nLink.LinkAdd(child); err != nil {
+		return fmt.Errorf("failed to create IPoIB child of %s for pkey %s: %v", conf.Master, conf.PKey, err)
+	}
+
+	childLink, err := m.nLink.LinkByName(tempName)
+	if err != nil {
+		if dErr := m.nLink.LinkDel(child); dErr != nil {
+			logging.Warning("failed to delete IPoIB child while rolling back", "ifname", tempName, "error", dErr)
+		}
+		return fmt.Errorf("failed to get IPoIB child %s: %v", tempName, err)
+	}
+
+	if err = m.nLink.LinkSetNsFd(childLink, int(netns.Fd())); err != nil {
+		if dErr := m.nLink.LinkDel(childLink); dErr != nil {
+			logging.Warning("failed to delete IPoIB child while rolling back", "ifname", tempName, "error", dErr)
+		}
+		return fmt.Errorf("failed to move IPoIB child %s to netns: %v", tempName, err)
+	}
+
+	if err = netns.Do(func(_ ns.NetNS) error {
+		if err := m.nLink.LinkSetName(childLink, podifName); err != nil {
+			return fmt.Errorf("error setting container interface name %s for %s: %v", podifName, tempName, err)
+		}
+		if err := m.nLink.LinkSetUp(childLink); err != nil {
+			return fmt.Errorf("error bringing interface up in container ns: %v", err)
+		}
+		return nil
+	}); err != nil {
+		if dErr := netns.Do(func(_ ns.NetNS) error {
+			return m.nLink.LinkDel(childLink)
+		}); dErr != nil {
+			logging.Warning("failed to delete IPoIB child while rolling back", "ifname", childLink.Attrs().Name, "error", dErr)
+		}
+		return fmt.Errorf("error setting up interface in container namespace: %v", err)
+	}
+	conf.ContIFNames = podifName
+
+	return nil
+}
+
+// ReleaseVF deletes the IPoIB child from Pod netns
+func (m *ipoibManager) ReleaseVF(conf *types.NetConf, podifName, cid string, netns ns.NetNS) error {
+	return netns.Do(func(_ ns.NetNS) error {
+		linkObj, err := m.nLink.LinkByName(podifName)
+		if err != nil {
+			if _, ok := err.(netlink.LinkNotFoundError); ok {
+				return nil
+			}
+			return fmt.Errorf("failed to get netlink device with name %s: %v", podifName, err)
+		}
+
+		if linkObj.Type() != ipoibLinkType {
+			return fmt.Errorf("interface %s is not an IPoIB interface", podifName)
+		}
+
+		if err = m.nLink.LinkDel(linkObj); err != nil {
+			return fmt.Errorf("failed to delete IPoIB child %s: %v", podifName, err)
+		}
+		return nil
+	})
+}
+
+// ResetVFConfig does nothing, the PF is not configured
+func (m *ipoibManager) ResetVFConfig(conf *types.NetConf) error {
+	return nil
+}
+
+// RestoreVFName does nothing, the PF is not renamed
+func (m *ipoibManager) RestoreVFName(conf *types.NetConf) error {
+	return nil
+}
+
+// CheckVFConfig verifies that the IPoIB child of the pkey is up in Pod netns
+func (m *ipoibManager) CheckVFConfig(conf *types.NetConf, podifName string, netns ns.NetNS) error {
+	pkey, err := utils.ParsePKey(conf.PKey)
+	if err != nil {
+		return fmt.Errorf("invalid pkey %q: %v", conf.PKey, err)
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		linkObj, err := m.nLink.LinkByName(podifName)
+		if err != nil {
+			return fmt.Errorf("failed to get netlink device with name %s: %v", podifName, err)
+		}
+
+		child, ok := linkObj.(*netlink.IPoIB)
+		if !ok {
+			return fmt.Errorf("interface %s is not an IPoIB interface", podifName)
+		}
+		// the kernel always creates IPoIB children as full members of the partition
+		if child.Pkey|utils.PKeyFullMembership != pkey|utils.PKeyFullMembership {
+			return fmt.Errorf("interface %s pkey is 0x%04x, expected %s", podifName, child.Pkey, conf.PKey)
+		}
+
+		if linkObj.Attrs().Flags&net.FlagUp == 0 {
+			return fmt.Errorf("interface %s is down", podifName)
+		}
+
+		if conf.MTU != 0 && linkObj.Attrs().MTU != conf.MTU {
+			return fmt.Errorf("interface %s mtu is %d, expected %d", podifName, linkObj.Attrs().MTU, conf.MTU)
+		}
+		return nil
+	})
+}
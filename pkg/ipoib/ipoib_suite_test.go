@@ -0,0 +1,13 @@
+package ipoib
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestIPoIB(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "IPoIB Suite")
+}
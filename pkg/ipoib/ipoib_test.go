@@ -0,0 +1,217 @@
+package ipoib
+
+import (
+	"errors"
+	"net"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types/mocks"
+)
+
+// Fake NS - implements ns.NetNS interface
+type fakeNetNS struct {
+	fd   uintptr
+	path string
+}
+
+func (f *fakeNetNS) Do(toRun func(ns.NetNS) error) error {
+	return toRun(f)
+}
+
+func (f *fakeNetNS) Set() error {
+	return nil
+}
+
+func (f *fakeNetNS) Path() string {
+	return f.path
+}
+
+func (f *fakeNetNS) Fd() uintptr {
+	return f.fd
+}
+
+func (f *fakeNetNS) Close() error {
+	return nil
+}
+
+func newFakeNs() ns.NetNS {
+	return &fakeNetNS{
+		fd:   17,
+		path: "/proc/4123/ns/net",
+	}
+}
+
+var _ = Describe("IPoIB", func() {
+	var (
+		podifName string
+		contID    string
+		netconf   *types.NetConf
+		pfLink    *netlink.Device
+	)
+
+	BeforeEach(func() {
+		podifName = "net1"
+		contID = "dummycid"
+		netconf = &types.NetConf{
+			IbSriovNetConf: types.IbSriovNetConf{
+				Master:      "ib0",
+				DeviceID:    "0000:af:00.1",
+				PKey:        "0x8005",
+				PFChildMode: true,
+			},
+		}
+		pfLink = &netlink.Device{LinkAttrs: netlink.LinkAttrs{Index: 10, Name: "ib0"}}
+	})
+
+	Context("Checking SetupVF function", func() {
+		It("Assuming existing PF", func() {
+			mocked := &mocks.NetlinkManager{}
+			childLink := &netlink.IPoIB{LinkAttrs: netlink.LinkAttrs{Index: 1000, ParentIndex: 10}, Pkey: 0x8005}
+
+			mocked.On("LinkByName", "ib0").Return(pfLink, nil)
+			mocked.On("LinkAdd", mock.MatchedBy(func(link *netlink.IPoIB) bool {
+				return link.ParentIndex == 10 && link.Pkey == 0x8005
+			})).Return(nil)
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(childLink, nil)
+			mocked.On("LinkSetNsFd", childLink, 17).Return(nil)
+			mocked.On("LinkSetName", childLink, podifName).Return(nil)
+			mocked.On("LinkSetUp", childLink).Return(nil)
+			m := ipoibManager{nLink: mocked}
+			err := m.SetupVF(netconf, podifName, contID, newFakeNs())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.ContIFNames).To(Equal(podifName))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming non existing PF", func() {
+			mocked := &mocks.NetlinkManager{}
+
+			mocked.On("LinkByName", "ib0").Return(nil, errors.New("not found"))
+			m := ipoibManager{nLink: mocked}
+			err := m.SetupVF(netconf, podifName, contID, newFakeNs())
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming failed to create IPoIB child", func() {
+			mocked := &mocks.NetlinkManager{}
+
+			mocked.On("LinkByName", "ib0").Return(pfLink, nil)
+			mocked.On("LinkAdd", mock.Anything).Return(errors.New("failed"))
+			m := ipoibManager{nLink: mocked}
+			err := m.SetupVF(netconf, podifName, contID, newFakeNs())
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming failed to move IPoIB child", func() {
+			mocked := &mocks.NetlinkManager{}
+			childLink := &netlink.IPoIB{LinkAttrs: netlink.LinkAttrs{Index: 1000, ParentIndex: 10}, Pkey: 0x8005}
+
+			mocked.On("LinkByName", "ib0").Return(pfLink, nil)
+			mocked.On("LinkAdd", mock.Anything).Return(nil)
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(childLink, nil)
+			mocked.On("LinkSetNsFd", childLink, 17).Return(errors.New("failed"))
+			mocked.On("LinkDel", childLink).Return(nil)
+			m := ipoibManager{nLink: mocked}
+			err := m.SetupVF(netconf, podifName, contID, newFakeNs())
+			Expect(err).To(HaveOccurred())
+			mocked.AssertCalled(GinkgoT(), "LinkDel", childLink)
+		})
+		It("Assuming failed to rename IPoIB child in pod netns", func() {
+			mocked := &mocks.NetlinkManager{}
+			childLink := &netlink.IPoIB{LinkAttrs: netlink.LinkAttrs{Index: 1000, ParentIndex: 10}, Pkey: 0x8005}
+
+			mocked.On("LinkByName", "ib0").Return(pfLink, nil)
+			mocked.On("LinkAdd", mock.Anything).Return(nil)
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(childLink, nil)
+			mocked.On("LinkSetNsFd", childLink, 17).Return(nil)
+			mocked.On("LinkSetName", childLink, podifName).Return(errors.New("failed"))
+			mocked.On("LinkDel", childLink).Return(nil)
+			m := ipoibManager{nLink: mocked}
+			err := m.SetupVF(netconf, podifName, contID, newFakeNs())
+			Expect(err).To(HaveOccurred())
+			mocked.AssertCalled(GinkgoT(), "LinkDel", childLink)
+		})
+	})
+	Context("Checking ReleaseVF function", func() {
+		It("Assuming existing IPoIB child", func() {
+			mocked := &mocks.NetlinkManager{}
+			childLink := &netlink.IPoIB{LinkAttrs: netlink.LinkAttrs{Index: 1000, Name: podifName}, Pkey: 0x8005}
+
+			mocked.On("LinkByName", podifName).Return(childLink, nil)
+			mocked.On("LinkDel", childLink).Return(nil)
+			m := ipoibManager{nLink: mocked}
+			err := m.ReleaseVF(netconf, podifName, contID, newFakeNs())
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming non existing IPoIB child", func() {
+			mocked := &mocks.NetlinkManager{}
+
+			mocked.On("LinkByName", podifName).Return(nil, netlink.LinkNotFoundError{})
+			m := ipoibManager{nLink: mocked}
+			err := m.ReleaseVF(netconf, podifName, contID, newFakeNs())
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming pod interface is not an IPoIB interface", func() {
+			mocked := &mocks.NetlinkManager{}
+			link := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Index: 1000, Name: podifName}}
+
+			mocked.On("LinkByName", podifName).Return(link, nil)
+			m := ipoibManager{nLink: mocked}
+			err := m.ReleaseVF(netconf, podifName, contID, newFakeNs())
+			Expect(err).To(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "LinkDel", mock.Anything)
+		})
+	})
+	Context("Checking CheckVFConfig function", func() {
+		It("Assuming IPoIB child matches configuration", func() {
+			mocked := &mocks.NetlinkManager{}
+			childLink := &netlink.IPoIB{
+				LinkAttrs: netlink.LinkAttrs{Index: 1000, Name: podifName, Flags: net.FlagUp},
+				Pkey:      0x8005,
+			}
+
+			mocked.On("LinkByName", podifName).Return(childLink, nil)
+			m := ipoibManager{nLink: mocked}
+			err := m.CheckVFConfig(netconf, podifName, newFakeNs())
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming IPoIB child of a limited membership pkey", func() {
+			mocked := &mocks.NetlinkManager{}
+			netconf.PKey = "0x0005"
+			childLink := &netlink.IPoIB{
+				LinkAttrs: netlink.LinkAttrs{Index: 1000, Name: podifName, Flags: net.FlagUp},
+				Pkey:      0x8005,
+			}
+
+			mocked.On("LinkByName", podifName).Return(childLink, nil)
+			m := ipoibManager{nLink: mocked}
+			err := m.CheckVFConfig(netconf, podifName, newFakeNs())
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming IPoIB child pkey mismatch", func() {
+			mocked := &mocks.NetlinkManager{}
+			childLink := &netlink.IPoIB{
+				LinkAttrs: netlink.LinkAttrs{Index: 1000, Name: podifName, Flags: net.FlagUp},
+				Pkey:      0x8006,
+			}
+
+			mocked.On("LinkByName", podifName).Return(childLink, nil)
+			m := ipoibManager{nLink: mocked}
+			err := m.CheckVFConfig(netconf, podifName, newFakeNs())
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming IPoIB child is down", func() {
+			mocked := &mocks.NetlinkManager{}
+			childLink := &netlink.IPoIB{LinkAttrs: netlink.LinkAttrs{Index: 1000, Name: podifName}, Pkey: 0x8005}
+
+			mocked.On("LinkByName", podifName).Return(childLink, nil)
+			m := ipoibManager{nLink: mocked}
+			err := m.CheckVFConfig(netconf, podifName, newFakeNs())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,209 @@
+// Package journal records the steps started by a CNI ADD operation so that they can be undone
+// if the plugin is interrupted before the attachment is cached. Steps are recorded before they are
+// started, undoing a step checks what it left behind.
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/guidpool"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/logging"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
+)
+
+// Steps of the ADD operation recorded in the journal
+const (
+	// StepGUIDPool VF GUID allocated from the guidPool
+	StepGUIDPool = "guidPool"
+	// StepVFConfig VF GUID and link state applied, VF rebound
+	StepVFConfig = "vfConfig"
+	// StepNodeDesc VF RDMA device node description set
+	StepNodeDesc = "nodeDesc"
+	// StepRdmaMoved VF RDMA device moved to the pod network namespace
+	StepRdmaMoved = "rdmaMoved"
+	// StepVFSetup VF netdevice renamed and moved to the pod network namespace
+	StepVFSetup = "vfSetup"
+	// StepIPAM IP addresses allocated by the IPAM plugin
+	StepIPAM = "ipam"
+)
+
+// Journal holds the steps started so far by the ADD operation of an attachment
+type Journal struct {
+	path        string
+	ContainerID string         `json:"containerID"`
+	IfName      string         `json:"ifName"`
+	Steps       []string       `json:"steps"`
+	NetConf     *types.NetConf `json:"netConf"`
+}
+
+// New returns an empty journal of the attachment stored at path
+func New(path, containerID, ifName string, netConf *types.NetConf) *Journal {
+	return &Journal{
+		path:        path,
+		ContainerID: containerID,
+		IfName:      ifName,
+		Steps:       []string{},
+		NetConf:     netConf,
+	}
+}
+
+// Load reads the journal stored at path, it returns nil if no journal exists
+func Load(path string) (*Journal, error) {
+	data, err := os.ReadFile(path) /* #nosec G304 */
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read journal %s: %v", path, err)
+	}
+
+	j := &Journal{path: path}
+	if err = json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("failed to parse journal %s: %v", path, err)
+	}
+	return j, nil
+}
+
+// Path returns the path the journal is stored at
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Record marks step as started and persists the journal along with the current NetConf. Recording a step
+// again persists the NetConf updated by the step.
+func (j *Journal) Record(step string) error {
+	if !j.Has(step) {
+		j.Steps = append(j.Steps, step)
+	}
+	return j.save()
+}
+
+// Has returns true if step was started
+func (j *Journal) Has(step string) bool {
+	return slices.Contains(j.Steps, step)
+}
+
+// Remove deletes the journal from disk
+func (j *Journal) Remove() error {
+	return Discard(j.path)
+}
+
+// Discard deletes the journal stored at path if it exists
+func Discard(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal %s: %v", path, err)
+	}
+	return nil
+}
+
+func (j *Journal) save() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("failed to serialize journal: %v", err)
+	}
+
+	dir := filepath.Dir(j.path)
+	if err = os.MkdirAll(dir, utils.OwnerReadWriteExecuteAttrs); err != nil {
+		return fmt.Errorf("failed to create journal directory %s: %v", dir, err)
+	}
+
+	// write to a temporary file first so that an interrupted write never leaves a truncated journal
+	tmpPath := j.path + ".tmp"
+	if err = os.WriteFile(tmpPath, data, utils.OwnerReadWriteAttrs); err != nil {
+		return fmt.Errorf("failed to write journal %s: %v", tmpPath, err)
+	}
+	if err = os.Rename(tmpPath, j.path); err != nil {
+		return fmt.Errorf("failed to write journal %s: %v", j.path, err)
+	}
+	return nil
+}
+
+// Undo reverts the recorded steps in reverse order. IPAM allocations are released only if stdinData is provided.
+// Undo carries on after failures and returns all of them.
+func (j *Journal) Undo(sm types.Manager, stdinData []byte) error {
+	conf := j.NetConf
+	var errs []error
+
+	if j.Has(StepIPAM) && stdinData != nil {
+		if err := ipam.ExecDel(conf.IPAM.Type, stdinData); err != nil {
+			errs = append(errs, fmt.Errorf("failed to release IPAM allocation: %v", err))
+		}
+	}
+
+	if j.Has(StepVFSetup) || j.Has(StepRdmaMoved) {
+		netns, err := ns.GetNS(conf.NetnsPath)
+		if err == nil {
+			errs = append(errs, j.undoNetnsSteps(sm, netns)...)
+			_ = netns.Close()
+		} else {
+			// Pod network namespace is gone, the kernel returned the VF netdevice and RDMA device
+			// to the default namespace
+			logging.Debug("pod netns no longer exists", "netns", conf.NetnsPath, "error", err)
+		}
+	}
+
+	if j.Has(StepNodeDesc) && conf.HostNodeDesc != "" {
+		if err := utils.SetPciRdmaDevNodeDesc(conf.DeviceID, conf.HostNodeDesc); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore node description: %v", err))
+		}
+	}
+
+	if j.Has(StepVFConfig) {
+		// interrupted before the original GUIDs of the VF were saved, the GUID may have been programmed
+		if conf.HostIFNodeGUID == "" && conf.HostIFPortGUID == "" && conf.HostIFGUID == "" && !conf.PFChildMode {
+			conf.HostIFNodeGUID, conf.HostIFPortGUID = utils.DefaultGUID, utils.DefaultGUID
+		}
+		if err := sm.ResetVFConfig(conf); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reset VF config: %v", err))
+		}
+	}
+
+	// the GUID is returned to the pool only once the VF no longer holds it
+	if j.Has(StepGUIDPool) && len(errs) == 0 {
+		err := guidpool.Release(config.GetGUIDPoolDir(), config.GetAttachmentID(j.ContainerID, j.IfName),
+			config.GetLockTimeout(conf))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to release GUID %s to the guidPool: %v", conf.GUID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (j *Journal) undoNetnsSteps(sm types.Manager, netns ns.NetNS) []error {
+	conf := j.NetConf
+	var errs []error
+
+	if j.Has(StepVFSetup) && hasLink(netns, j.IfName) {
+		if err := sm.ReleaseVF(conf, j.IfName, j.ContainerID, netns); err != nil {
+			errs = append(errs, fmt.Errorf("failed to release VF: %v", err))
+		}
+	}
+
+	if j.Has(StepRdmaMoved) && utils.CheckRdmaDevInNs(conf.RdmaNetState.ContainerRdmaDevName, netns) == nil {
+		if err := utils.MoveRdmaDevFromNs(conf.RdmaNetState.ContainerRdmaDevName, netns); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore RDMA device %s to default namespace: %v",
+				conf.RdmaNetState.ContainerRdmaDevName, err))
+		}
+	}
+	return errs
+}
+
+// hasLink checks if the link exists in the network namespace
+func hasLink(netns ns.NetNS, ifName string) bool {
+	return netns.Do(func(_ ns.NetNS) error {
+		_, err := netlink.LinkByName(ifName)
+		return err
+	}) == nil
+}
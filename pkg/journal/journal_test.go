@@ -0,0 +1,159 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/guidpool"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types/mocks"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
+)
+
+var _ = Describe("Journal", func() {
+	var (
+		tmpDir      string
+		journalPath string
+		netConf     *types.NetConf
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = os.MkdirTemp("", "ib-sriov-cni-journal-")
+		Expect(err).NotTo(HaveOccurred())
+		journalPath = filepath.Join(tmpDir, "journal", "container-net1")
+		netConf = &types.NetConf{}
+		netConf.DeviceID = "0000:af:06.0"
+		netConf.NetnsPath = filepath.Join(tmpDir, "missing-netns")
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	Context("Checking journal persistence", func() {
+		It("Assuming steps recorded", func() {
+			j := New(journalPath, "container", "net1", netConf)
+			Expect(j.Record(StepVFConfig)).To(Succeed())
+			netConf.HostIFGUID = "00:00:00:00:00:00:00:01"
+			Expect(j.Record(StepVFSetup)).To(Succeed())
+
+			loaded, err := Load(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(loaded.Path()).To(Equal(journalPath))
+			Expect(loaded.ContainerID).To(Equal("container"))
+			Expect(loaded.IfName).To(Equal("net1"))
+			Expect(loaded.Steps).To(Equal([]string{StepVFConfig, StepVFSetup}))
+			Expect(loaded.Has(StepRdmaMoved)).To(BeFalse())
+			Expect(loaded.NetConf.HostIFGUID).To(Equal("00:00:00:00:00:00:00:01"))
+		})
+		It("Assuming step recorded twice", func() {
+			j := New(journalPath, "container", "net1", netConf)
+			Expect(j.Record(StepVFConfig)).To(Succeed())
+			Expect(j.Record(StepVFConfig)).To(Succeed())
+			Expect(j.Steps).To(Equal([]string{StepVFConfig}))
+		})
+		It("Assuming missing journal", func() {
+			j, err := Load(journalPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(j).To(BeNil())
+		})
+		It("Assuming corrupted journal", func() {
+			Expect(os.MkdirAll(filepath.Dir(journalPath), 0o700)).To(Succeed())
+			Expect(os.WriteFile(journalPath, []byte("{"), 0o600)).To(Succeed())
+			_, err := Load(journalPath)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming journal removed", func() {
+			j := New(journalPath, "container", "net1", netConf)
+			Expect(j.Record(StepVFConfig)).To(Succeed())
+			Expect(j.Remove()).To(Succeed())
+			_, err := os.Stat(journalPath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+			Expect(Discard(journalPath)).To(Succeed())
+		})
+	})
+	Context("Checking Undo function", func() {
+		It("Assuming no recorded steps", func() {
+			sm := &mocks.Manager{}
+			j := New(journalPath, "container", "net1", netConf)
+			Expect(j.Undo(sm, nil)).To(Succeed())
+			sm.AssertExpectations(GinkgoT())
+		})
+		It("Assuming VF configured and pod netns gone", func() {
+			sm := &mocks.Manager{}
+			sm.On("ResetVFConfig", netConf).Return(nil)
+			j := New(journalPath, "container", "net1", netConf)
+			j.Steps = []string{StepVFConfig, StepRdmaMoved, StepVFSetup}
+			Expect(j.Undo(sm, nil)).To(Succeed())
+			sm.AssertExpectations(GinkgoT())
+			sm.AssertNotCalled(GinkgoT(), "ReleaseVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("Assuming VF config reset fails", func() {
+			sm := &mocks.Manager{}
+			sm.On("ResetVFConfig", netConf).Return(errors.New("failed"))
+			j := New(journalPath, "container", "net1", netConf)
+			j.Steps = []string{StepVFConfig}
+			Expect(j.Undo(sm, nil)).To(HaveOccurred())
+		})
+		It("Assuming interrupted before the VF original GUIDs were saved", func() {
+			sm := &mocks.Manager{}
+			sm.On("ResetVFConfig", netConf).Return(nil)
+			j := New(journalPath, "container", "net1", netConf)
+			j.Steps = []string{StepVFConfig}
+			Expect(j.Undo(sm, nil)).To(Succeed())
+			sm.AssertExpectations(GinkgoT())
+			Expect(netConf.HostIFNodeGUID).To(Equal(utils.DefaultGUID))
+			Expect(netConf.HostIFPortGUID).To(Equal(utils.DefaultGUID))
+		})
+		It("Assuming interrupted before the VF original node description was saved", func() {
+			sm := &mocks.Manager{}
+			j := New(journalPath, "container", "net1", netConf)
+			j.Steps = []string{StepNodeDesc}
+			Expect(j.Undo(sm, nil)).To(Succeed())
+			sm.AssertExpectations(GinkgoT())
+		})
+		Context("Assuming GUID allocated from the guidPool", func() {
+			var (
+				origCNIDir string
+				pool       *guidpool.Pool
+			)
+
+			BeforeEach(func() {
+				origCNIDir = config.DefaultCNIDir
+				config.DefaultCNIDir = tmpDir
+				var err error
+				pool, err = guidpool.New(config.GetGUIDPoolDir(), []string{"02:00:00:00:00:00:00:01"}, config.DefaultLockTimeout)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pool.Allocate(config.GetAttachmentID("container", "net1"), "ibnet")).
+					To(Equal("02:00:00:00:00:00:00:01"))
+			})
+			AfterEach(func() {
+				config.DefaultCNIDir = origCNIDir
+			})
+
+			It("Assuming VF config reset", func() {
+				sm := &mocks.Manager{}
+				sm.On("ResetVFConfig", netConf).Return(nil)
+				j := New(journalPath, "container", "net1", netConf)
+				j.Steps = []string{StepGUIDPool, StepVFConfig}
+				Expect(j.Undo(sm, nil)).To(Succeed())
+				Expect(guidpool.Lookup(config.GetGUIDPoolDir(), config.GetAttachmentID("container", "net1"), config.DefaultLockTimeout)).To(BeEmpty())
+			})
+			It("Assuming VF config reset fails", func() {
+				sm := &mocks.Manager{}
+				sm.On("ResetVFConfig", netConf).Return(errors.New("failed"))
+				j := New(journalPath, "container", "net1", netConf)
+				j.Steps = []string{StepGUIDPool, StepVFConfig}
+				Expect(j.Undo(sm, nil)).To(HaveOccurred())
+				Expect(guidpool.Lookup(config.GetGUIDPoolDir(), config.GetAttachmentID("container", "net1"), config.DefaultLockTimeout)).
+					To(Equal("02:00:00:00:00:00:00:01"), "GUID held by the VF must not be released")
+			})
+		})
+	})
+})
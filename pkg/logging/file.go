@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gofrs/flock"
+)
+
+const (
+	logFileAttrs      = 0600
+	maxLogFileSize    = 100 * 1024 * 1024
+	maxLogFileBackups = 5
+)
+
+// logFileWriter appends to a log file shared by concurrent CNI invocations and rotates it by size. Each write
+// holds the lock of a sidecar lock file, so that the file is rotated by a single invocation and the others reopen
+// it before writing.
+type logFileWriter struct {
+	path    string
+	maxSize int64
+	lock    *flock.Flock
+	file    *os.File
+}
+
+func newLogFileWriter(path string, maxSize int64) (*logFileWriter, error) {
+	w := &logFileWriter{path: path, maxSize: maxSize, lock: flock.New(path + ".lock")}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the log file in append mode, each write is appended atomically so lines of concurrent invocations
+// don't overwrite each other
+func (w *logFileWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, logFileAttrs) /* #nosec G304 */
+	if err != nil {
+		return err
+	}
+	if w.file != nil {
+		_ = w.file.Close()
+	}
+	w.file = f
+	return nil
+}
+
+func (w *logFileWriter) Write(p []byte) (int, error) {
+	if err := w.lock.Lock(); err != nil {
+		// the line is written without rotating the file rather than lost
+		return w.file.Write(p)
+	}
+	defer func() { _ = w.lock.Unlock() }()
+
+	if err := w.rotate(int64(len(p))); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to rotate log file %s: %v\n", w.path, err)
+	}
+	return w.file.Write(p)
+}
+
+// rotate reopens the log file if it was rotated by another invocation, then rotates it if writing n more bytes
+// would exceed the max size. The backups are the file name suffixed with .1 (the newest) to .maxLogFileBackups.
+// It must be called while holding the lock.
+func (w *logFileWriter) rotate(n int64) error {
+	info, err := os.Stat(w.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err != nil || !w.isOpen(info) {
+		if err = w.open(); err != nil {
+			return err
+		}
+		if info, err = w.file.Stat(); err != nil {
+			return err
+		}
+	}
+	if info.Size() == 0 || info.Size()+n <= w.maxSize {
+		return nil
+	}
+
+	for i := maxLogFileBackups - 1; i > 0; i-- {
+		err = os.Rename(backupPath(w.path, i), backupPath(w.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err = os.Rename(w.path, backupPath(w.path, 1)); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+// isOpen checks if info is the one of the open log file
+func (w *logFileWriter) isOpen(info os.FileInfo) bool {
+	openInfo, err := w.file.Stat()
+	return err == nil && os.SameFile(openInfo, info)
+}
+
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
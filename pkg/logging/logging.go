@@ -0,0 +1,103 @@
+// Package logging provides leveled, structured logging for ib-sriov-cni.
+// Logs are written to stderr unless a log file is configured. The log file is shared by
+// concurrent CNI invocations, it is appended to and rotated once it reaches maxLogFileSize.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Supported log levels
+const (
+	LevelDebug   = "debug"
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+var levels = map[string]slog.Level{
+	LevelDebug:   slog.LevelDebug,
+	LevelInfo:    slog.LevelInfo,
+	LevelWarning: slog.LevelWarn,
+	LevelError:   slog.LevelError,
+}
+
+var (
+	level            = new(slog.LevelVar)
+	output io.Writer = os.Stderr
+	fields []any
+	logger = newLogger()
+)
+
+func newLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(output, &slog.HandlerOptions{Level: level})).With(fields...)
+}
+
+// IsValidLevel checks if the given log level is supported, an empty level is valid and means the default level
+func IsValidLevel(logLevel string) bool {
+	if logLevel == "" {
+		return true
+	}
+	_, ok := levels[strings.ToLower(logLevel)]
+	return ok
+}
+
+// Init sets the log level and log file. An empty log level keeps the default (info) level,
+// an empty log file keeps logging to stderr, as does a log file which can't be opened.
+func Init(logLevel, logFile string) {
+	if l, ok := levels[strings.ToLower(logLevel)]; ok {
+		level.Set(l)
+	}
+
+	if logFile != "" {
+		w, err := newLogFileWriter(logFile, maxLogFileSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open log file %s, logging to stderr: %v\n", logFile, err)
+		} else {
+			output = w
+		}
+	}
+	logger = newLogger()
+}
+
+// SetOutput sets the writer logs are written to
+func SetOutput(w io.Writer) {
+	output = w
+	logger = newLogger()
+}
+
+// AddFields adds key-value pairs which are attached to every subsequent log line
+func AddFields(args ...any) {
+	fields = append(fields, args...)
+	logger = newLogger()
+}
+
+// ResetFields removes all key-value pairs added by AddFields
+func ResetFields() {
+	fields = nil
+	logger = newLogger()
+}
+
+// Debug logs a message with optional key-value pairs at debug level
+func Debug(msg string, args ...any) {
+	logger.Debug(msg, args...)
+}
+
+// Info logs a message with optional key-value pairs at info level
+func Info(msg string, args ...any) {
+	logger.Info(msg, args...)
+}
+
+// Warning logs a message with optional key-value pairs at warning level
+func Warning(msg string, args ...any) {
+	logger.Warn(msg, args...)
+}
+
+// Error logs a message with optional key-value pairs at error level
+func Error(msg string, args ...any) {
+	logger.Error(msg, args...)
+}
@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging", func() {
+	var buf *bytes.Buffer
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		Init(LevelInfo, "")
+		SetOutput(buf)
+	})
+	AfterEach(func() {
+		ResetFields()
+		SetOutput(os.Stderr)
+		Init(LevelInfo, "")
+	})
+
+	Context("Checking IsValidLevel function", func() {
+		It("Assuming supported levels", func() {
+			for _, l := range []string{"", LevelDebug, LevelInfo, LevelWarning, LevelError, "DEBUG"} {
+				Expect(IsValidLevel(l)).To(BeTrue(), "level %q should be valid", l)
+			}
+		})
+		It("Assuming unsupported level", func() {
+			Expect(IsValidLevel("verbose")).To(BeFalse())
+		})
+	})
+	Context("Checking log output", func() {
+		It("Assuming structured fields are added to each line", func() {
+			AddFields("containerID", "cid", "ifname", "net1")
+			Info("attachment added", "guid", "01:23:45:67:89:ab:cd:ef")
+			Expect(buf.String()).To(ContainSubstring("level=INFO"))
+			Expect(buf.String()).To(ContainSubstring(`msg="attachment added"`))
+			Expect(buf.String()).To(ContainSubstring("containerID=cid ifname=net1 guid=01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming messages below log level are dropped", func() {
+			Debug("debug message")
+			Expect(buf.String()).To(BeEmpty())
+
+			Init(LevelDebug, "")
+			Debug("debug message")
+			Expect(buf.String()).To(ContainSubstring("level=DEBUG"))
+		})
+		It("Assuming error level", func() {
+			Init(LevelError, "")
+			Warning("warning message")
+			Expect(buf.String()).To(BeEmpty())
+			Error("error message")
+			Expect(buf.String()).To(ContainSubstring("level=ERROR"))
+		})
+		It("Assuming log file", func() {
+			tmpDir, err := os.MkdirTemp("", "ib-sriov-cni-logging-")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(tmpDir)
+
+			logFile := filepath.Join(tmpDir, "ib-sriov.log")
+			Init(LevelInfo, logFile)
+			Info("written to file")
+
+			data, err := os.ReadFile(logFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`msg="written to file"`))
+			Expect(buf.String()).To(BeEmpty())
+		})
+		It("Assuming log file shared by invocations", func() {
+			tmpDir, err := os.MkdirTemp("", "ib-sriov-cni-logging-")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(tmpDir)
+
+			logFile := filepath.Join(tmpDir, "ib-sriov.log")
+			Init(LevelInfo, logFile)
+			Info("first invocation")
+			Init(LevelInfo, logFile)
+			Info("second invocation")
+
+			data, err := os.ReadFile(logFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`msg="first invocation"`))
+			Expect(string(data)).To(ContainSubstring(`msg="second invocation"`))
+		})
+	})
+	Context("Checking log file rotation", func() {
+		var (
+			tmpDir  string
+			logFile string
+		)
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = os.MkdirTemp("", "ib-sriov-cni-logging-")
+			Expect(err).NotTo(HaveOccurred())
+			logFile = filepath.Join(tmpDir, "ib-sriov.log")
+		})
+		AfterEach(func() {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+
+		It("Assuming log file reaches the max size", func() {
+			w, err := newLogFileWriter(logFile, 16)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = w.Write([]byte("first line\n"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = w.Write([]byte("second line\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := os.ReadFile(logFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("second line\n"))
+			data, err = os.ReadFile(logFile + ".1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("first line\n"))
+		})
+		It("Assuming backups beyond the max number of backups", func() {
+			w, err := newLogFileWriter(logFile, 1)
+			Expect(err).NotTo(HaveOccurred())
+			for i := 0; i < maxLogFileBackups+2; i++ {
+				_, err = w.Write([]byte("line\n"))
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			_, err = os.Stat(backupPath(logFile, maxLogFileBackups))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = os.Stat(backupPath(logFile, maxLogFileBackups+1))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("Assuming log file rotated by another invocation", func() {
+			w1, err := newLogFileWriter(logFile, 16)
+			Expect(err).NotTo(HaveOccurred())
+			w2, err := newLogFileWriter(logFile, 16)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = w1.Write([]byte("first line\n"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = w1.Write([]byte("second line\n"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = w2.Write([]byte("third\n"))
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := os.ReadFile(logFile)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("third\n"))
+			data, err = os.ReadFile(backupPath(logFile, 1))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("second line\n"))
+			data, err = os.ReadFile(backupPath(logFile, 2))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("first line\n"))
+		})
+	})
+})
@@ -1,13 +1,19 @@
 package sriov
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/k8snetworkplumbingwg/sriovnet"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/config"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/logging"
 	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
 )
@@ -21,6 +27,21 @@ func (n *MyNetlink) LinkByName(name string) (netlink.Link, error) {
 	return netlink.LinkByName(name)
 }
 
+// LinkByIndex implements NetlinkManager
+func (n *MyNetlink) LinkByIndex(index int) (netlink.Link, error) {
+	return netlink.LinkByIndex(index)
+}
+
+// LinkAdd using NetlinkManager
+func (n *MyNetlink) LinkAdd(link netlink.Link) error {
+	return netlink.LinkAdd(link)
+}
+
+// LinkDel using NetlinkManager
+func (n *MyNetlink) LinkDel(link netlink.Link) error {
+	return netlink.LinkDel(link)
+}
+
 // LinkSetUp using NetlinkManager
 func (n *MyNetlink) LinkSetUp(link netlink.Link) error {
 	return netlink.LinkSetUp(link)
@@ -41,6 +62,11 @@ func (n *MyNetlink) LinkSetName(link netlink.Link, name string) error {
 	return netlink.LinkSetName(link, name)
 }
 
+// LinkSetMTU using NetlinkManager
+func (n *MyNetlink) LinkSetMTU(link netlink.Link, mtu int) error {
+	return netlink.LinkSetMTU(link, mtu)
+}
+
 // LinkSetVfState using NetlinkManager
 func (n *MyNetlink) LinkSetVfState(link netlink.Link, vf int, state uint32) error {
 	return netlink.LinkSetVfState(link, vf, state)
@@ -61,6 +87,113 @@ func (n *MyNetlink) LinkDelAltName(link netlink.Link, altName string) error {
 	return netlink.LinkDelAltName(link, altName)
 }
 
+// LinkVfGUIDs returns the node and port GUIDs of the VFs of the PF link. The netlink library does not report
+// the InfiniBand GUIDs of VFs, they are parsed from the VF info of the link.
+func (n *MyNetlink) LinkVfGUIDs(link netlink.Link) ([]types.VfGUIDs, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETLINK, unix.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(unix.IFLA_EXT_MASK, nl.Uint32Attr(nl.RTEXT_FILTER_VF)))
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWLINK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VF info of %s: %v", link.Attrs().Name, err)
+	}
+	if len(msgs) == 0 || len(msgs[0]) < unix.SizeofIfInfomsg {
+		return nil, fmt.Errorf("no link info returned for %s", link.Attrs().Name)
+	}
+	return parseVfGUIDs(msgs[0][unix.SizeofIfInfomsg:])
+}
+
+// parseVfGUIDs parses the IB GUIDs of the VFs from the attributes of a link message
+func parseVfGUIDs(data []byte) ([]types.VfGUIDs, error) {
+	attrs, err := nl.ParseRouteAttr(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var vfs []types.VfGUIDs
+	for _, attr := range attrs {
+		if attr.Attr.Type != unix.IFLA_VFINFO_LIST {
+			continue
+		}
+		vfInfos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, vfInfo := range vfInfos {
+			vfAttrs, err := nl.ParseRouteAttr(vfInfo.Value)
+			if err != nil {
+				return nil, err
+			}
+			vf := types.VfGUIDs{VF: -1}
+			for _, vfAttr := range vfAttrs {
+				if vfAttr.Attr.Type != nl.IFLA_VF_IB_NODE_GUID && vfAttr.Attr.Type != nl.IFLA_VF_IB_PORT_GUID {
+					continue
+				}
+				if len(vfAttr.Value) < nl.SizeofVfGUID {
+					return nil, fmt.Errorf("invalid VF GUID attribute length %d", len(vfAttr.Value))
+				}
+				vfGUID := nl.DeserializeVfGUID(vfAttr.Value)
+				guid := make(net.HardwareAddr, 8)
+				binary.BigEndian.PutUint64(guid, vfGUID.GUID)
+				vf.VF = int(vfGUID.Vf)
+				if vfAttr.Attr.Type == nl.IFLA_VF_IB_NODE_GUID {
+					vf.NodeGUID = guid.String()
+				} else {
+					vf.PortGUID = guid.String()
+				}
+			}
+			// drivers without support of querying VF GUIDs report no GUID
+			if vf.VF >= 0 {
+				vfs = append(vfs, vf)
+			}
+		}
+	}
+	return vfs, nil
+}
+
+// GUIDHolder identifies the VF holding a GUID
+type GUIDHolder struct {
+	PF         string
+	VF         int
+	PciAddress string
+}
+
+// FindGUIDHolder returns the VF, other than the VF deviceID, of the SR-IOV enabled InfiniBand PFs of the node
+// whose node or port GUID is guid, or nil if there is none
+func FindGUIDHolder(nLink types.NetlinkManager, guid, deviceID string) (*GUIDHolder, error) {
+	pfs, err := utils.GetSriovIBPfs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pf := range pfs {
+		pfLink, err := nLink.LinkByName(pf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup PF %q: %v", pf, err)
+		}
+		vfs, err := nLink.LinkVfGUIDs(pfLink)
+		if err != nil {
+			return nil, err
+		}
+		for _, vf := range vfs {
+			if !strings.EqualFold(vf.NodeGUID, guid) && !strings.EqualFold(vf.PortGUID, guid) {
+				continue
+			}
+			pciAddr, err := utils.GetPciAddress(pf, vf.VF)
+			if err != nil {
+				return nil, err
+			}
+			if pciAddr != deviceID {
+				return &GUIDHolder{PF: pf, VF: vf.VF, PciAddress: pciAddr}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
 type pciUtilsImpl struct{}
 
 func (p *pciUtilsImpl) GetSriovNumVfs(ifName string) (int, error) {
@@ -157,31 +290,205 @@ func (s *sriovManager) SetupVF(conf *types.NetConf, podifName, cid string, netns
 		}
 	}
 
-	// 4. Change netns
+	// 4. Set IPoIB mode and MTU
+	if err := s.setIPoIBConfig(conf, linkObj); err != nil {
+		return err
+	}
+
+	// 5. Change netns
 	if err := s.nLink.LinkSetNsFd(linkObj, int(netns.Fd())); err != nil {
 		return fmt.Errorf("failed to move IF %s to netns: %q", tempName, err)
 	}
 
 	if err := netns.Do(func(_ ns.NetNS) error {
-		// 5. Set Pod IF name
-		if err := s.nLink.LinkSetName(linkObj, podifName); err != nil {
-			return fmt.Errorf("error setting container interface name %s for %s", linkName, tempName)
+		// 6. Set Pod IF name, the VF keeps its temp name if it is the parent of an IPoIB child
+		if !conf.IPoIBChildPKey {
+			if err := s.nLink.LinkSetName(linkObj, podifName); err != nil {
+				return fmt.Errorf("error setting container interface name %s for %s", linkName, tempName)
+			}
 		}
 
-		// 6. Bring IF up in Pod netns
+		// 7. Bring IF up in Pod netns
 		if err := s.nLink.LinkSetUp(linkObj); err != nil {
 			return fmt.Errorf("error bringing interface up in container ns: %q", err)
 		}
 
+		// 8. Create the IPoIB child of the pkey with the Pod IF name
+		if conf.IPoIBChildPKey {
+			return s.addIPoIBChild(conf, linkObj, podifName)
+		}
+
 		return nil
 	}); err != nil {
 		return fmt.Errorf("error setting up interface in container namespace: %q", err)
 	}
 	conf.ContIFNames = podifName
+	if conf.IPoIBChildPKey {
+		conf.ContParentIFName = tempName
+	}
+
+	return nil
+}
+
+// setIPoIBConfig sets the configured IPoIB mode and MTU of the VF netdevice, saving the original values
+// to restore them on release. The mode is set first as changing it adjusts the MTU.
+func (s *sriovManager) setIPoIBConfig(conf *types.NetConf, linkObj netlink.Link) error {
+	if conf.IPoIBMode == "" && conf.MTU == 0 {
+		return nil
+	}
+	ifName := linkObj.Attrs().Name
+
+	hostMode, err := utils.GetIPoIBMode(ifName)
+	if err != nil {
+		return err
+	}
+	if conf.MTU != 0 {
+		mode := hostMode
+		if conf.IPoIBMode != "" {
+			mode = conf.IPoIBMode
+		}
+		if err = utils.ValidateIPoIBMTU(mode, conf.MTU); err != nil {
+			return fmt.Errorf("invalid mtu for VF %s: %v", conf.DeviceID, err)
+		}
+	}
+	conf.HostIFMTU = linkObj.Attrs().MTU
+
+	if conf.IPoIBMode != "" {
+		conf.HostIFIPoIBMode = hostMode
+		if err = utils.SetIPoIBMode(ifName, conf.IPoIBMode); err != nil {
+			return err
+		}
+	}
+
+	if conf.MTU != 0 {
+		if err = s.nLink.LinkSetMTU(linkObj, conf.MTU); err != nil {
+			if conf.HostIFIPoIBMode != "" {
+				if rErr := utils.SetIPoIBMode(ifName, conf.HostIFIPoIBMode); rErr != nil {
+					logging.Warning("failed to restore IPoIB mode while rolling back", "ifname", ifName, "error", rErr)
+				}
+			}
+			return fmt.Errorf("failed to set mtu of %s to %d: %v", ifName, conf.MTU, err)
+		}
+	}
+	return nil
+}
+
+// restoreIPoIBConfig restores the original IPoIB mode and MTU of the VF netdevice in init netns
+func (s *sriovManager) restoreIPoIBConfig(conf *types.NetConf) error {
+	if conf.HostIFIPoIBMode == "" && conf.HostIFMTU == 0 {
+		return nil
+	}
+
+	if conf.HostIFIPoIBMode != "" {
+		if err := utils.SetIPoIBMode(conf.HostIFNames, conf.HostIFIPoIBMode); err != nil {
+			return err
+		}
+	}
+
+	if conf.HostIFMTU != 0 {
+		linkObj, err := s.nLink.LinkByName(conf.HostIFNames)
+		if err != nil {
+			return fmt.Errorf("failed to get netlink device with name %s: %v", conf.HostIFNames, err)
+		}
+		if err = s.nLink.LinkSetMTU(linkObj, conf.HostIFMTU); err != nil {
+			return fmt.Errorf("failed to restore mtu of %s to %d: %v", conf.HostIFNames, conf.HostIFMTU, err)
+		}
+	}
+	return nil
+}
 
+// addIPoIBChild creates the IPoIB child of the pkey on top of the VF, it must be called in Pod netns.
+// On failure the VF is given the Pod IF name so that it can be released.
+func (s *sriovManager) addIPoIBChild(conf *types.NetConf, parent netlink.Link, podifName string) (retErr error) {
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		if err := s.nLink.LinkSetDown(parent); err != nil {
+			logging.Warning("failed to set VF down while rolling back", "ifname", parent.Attrs().Name, "error", err)
+			return
+		}
+		if err := s.nLink.LinkSetName(parent, podifName); err != nil {
+			logging.Warning("failed to rename VF while rolling back", "ifname", parent.Attrs().Name, "error", err)
+		}
+	}()
+
+	pkey, err := utils.ParsePKey(conf.PKey)
+	if err != nil {
+		return fmt.Errorf("invalid pkey %q: %v", conf.PKey, err)
+	}
+
+	child := &netlink.IPoIB{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        podifName,
+			ParentIndex: parent.Attrs().Index,
+			MTU:         conf.MTU,
+		},
+		Pkey: pkey,
+		// datagram mode if no mode is configured
+		Mode: netlink.StringToIPoIBMode[conf.IPoIBMode],
+	}
+	if err = s.nLink.LinkAdd(child); err != nil {
+		return fmt.Errorf("failed to create IPoIB child %s for pkey %s: %v", podifName, conf.PKey, err)
+	}
+
+	childLink, err := s.nLink.LinkByName(podifName)
+	if err != nil {
+		if dErr := s.nLink.LinkDel(child); dErr != nil {
+			logging.Warning("failed to delete IPoIB child while rolling back", "ifname", podifName, "error", dErr)
+		}
+		return fmt.Errorf("failed to get IPoIB child %s: %v", podifName, err)
+	}
+	if err = s.nLink.LinkSetUp(childLink); err != nil {
+		if dErr := s.nLink.LinkDel(childLink); dErr != nil {
+			logging.Warning("failed to delete IPoIB child while rolling back", "ifname", podifName, "error", dErr)
+		}
+		return fmt.Errorf("failed to bring IPoIB child %s up: %v", podifName, err)
+	}
 	return nil
 }
 
+// delIPoIBChild deletes the Pod IF if it is an IPoIB child and returns its parent VF, it must be called in Pod netns
+func (s *sriovManager) delIPoIBChild(link netlink.Link) (netlink.Link, error) {
+	parentIndex := link.Attrs().ParentIndex
+	if parentIndex == 0 || parentIndex == link.Attrs().Index {
+		// no child was created, the Pod IF is the VF
+		return link, nil
+	}
+
+	parent, err := s.nLink.LinkByIndex(parentIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get parent of IPoIB child %s: %v", link.Attrs().Name, err)
+	}
+	if err = s.nLink.LinkDel(link); err != nil {
+		return nil, fmt.Errorf("failed to delete IPoIB child %s: %v", link.Attrs().Name, err)
+	}
+	return parent, nil
+}
+
+// getPodVFLink returns the VF netdevice of the Pod IF, removing its IPoIB child first. An IPoIB child which is
+// not found was already deleted, its parent VF is returned. It must be called in Pod netns.
+func (s *sriovManager) getPodVFLink(conf *types.NetConf, podifName string) (netlink.Link, error) {
+	linkObj, err := s.nLink.LinkByName(podifName)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); !ok || !conf.IPoIBChildPKey || conf.ContParentIFName == "" {
+			return nil, fmt.Errorf("failed to get netlink device with name %s: %q", podifName, err)
+		}
+		linkObj, err = s.nLink.LinkByName(conf.ContParentIFName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get parent VF %s of deleted IPoIB child %s: %q", conf.ContParentIFName,
+				podifName, err)
+		}
+		return linkObj, nil
+	}
+
+	// remove the IPoIB child before returning its parent VF
+	if conf.IPoIBChildPKey {
+		return s.delIPoIBChild(linkObj)
+	}
+	return linkObj, nil
+}
+
 // ReleaseVF reset a VF from Pod netns and return it to init netns
 func (s *sriovManager) ReleaseVF(conf *types.NetConf, podifName, cid string, netns ns.NetNS) error {
 	initns, err := ns.GetCurrentNS()
@@ -195,11 +502,11 @@ func (s *sriovManager) ReleaseVF(conf *types.NetConf, podifName, cid string, net
 			len(conf.ContIFNames), len(conf.HostIFNames))
 	}
 
-	return netns.Do(func(_ ns.NetNS) error {
+	if err := netns.Do(func(_ ns.NetNS) error {
 		// get VF device
-		linkObj, err := s.nLink.LinkByName(podifName)
+		linkObj, err := s.getPodVFLink(conf, podifName)
 		if err != nil {
-			return fmt.Errorf("failed to get netlink device with name %s: %q", podifName, err)
+			return err
 		}
 
 		// shutdown VF device
@@ -219,33 +526,56 @@ func (s *sriovManager) ReleaseVF(conf *types.NetConf, podifName, cid string, net
 		}
 
 		return nil
-	})
+	}); err != nil {
+		return err
+	}
+
+	// IPoIB mode is set through sysfs of init netns
+	return s.restoreIPoIBConfig(conf)
 }
 
 // applyVFGuid handles VF GUID configuration and validation for both VFIO and regular VFs
 func (s *sriovManager) applyVFGuid(conf *types.NetConf, pfLink netlink.Link) error {
-	if conf.GUID != "" {
-		if !utils.IsValidGUID(conf.GUID) {
-			return fmt.Errorf("invalid guid %s", conf.GUID)
-		}
-
-		// For VFIO VF devices, we can't read current GUID from VF interface
-		if conf.VfioPciMode {
-			// Save all-F GUID to reset to during deletion
-			conf.HostIFGUID = utils.DefaultGUID
-		} else {
-			// Regular VF: save current GUID from VF link
-			vfLink, err := s.nLink.LinkByName(conf.HostIFNames)
-			if err != nil {
-				return fmt.Errorf("failed to lookup vf %q: %v", conf.HostIFNames, err)
+	nodeGUID, portGUID := conf.GetNodeGUID(), conf.GetPortGUID()
+	if nodeGUID != "" || portGUID != "" {
+		if nodeGUID != "" && !utils.IsValidGUID(nodeGUID) {
+			return fmt.Errorf("invalid node guid %s", nodeGUID)
+		}
+		if portGUID != "" && !utils.IsValidGUID(portGUID) {
+			return fmt.Errorf("invalid port guid %s", portGUID)
+		}
+
+		// Refuse a GUID already held by another VF, duplicate GUIDs on the fabric confuse the subnet manager. The
+		// nodeGUID of the network config may be shared by the VFs of a site, only a per-attachment node GUID is checked.
+		if !conf.IsNodeGUIDShared() {
+			if err := s.checkGUIDNotInUse(conf, nodeGUID); err != nil {
+				return err
+			}
+		}
+		if portGUID != nodeGUID || conf.IsNodeGUIDShared() {
+			if err := s.checkGUIDNotInUse(conf, portGUID); err != nil {
+				return err
 			}
-			conf.HostIFGUID = vfLink.Attrs().HardwareAddr.String()[36:]
+		}
+
+		if err := s.saveVfGUIDs(conf, pfLink); err != nil {
+			return err
+		}
+
+		// A GUID which is not requested keeps its original value
+		if nodeGUID == "" {
+			nodeGUID = hostGUIDToSet(conf.HostIFNodeGUID)
+		}
+		if portGUID == "" {
+			portGUID = hostGUIDToSet(conf.HostIFPortGUID)
 		}
 
 		// Set link guid
-		if err := s.setVfGUID(conf, pfLink, conf.GUID); err != nil {
+		if err := s.setVfGUID(conf, pfLink, nodeGUID, portGUID); err != nil {
 			return err
 		}
+		conf.AppliedNodeGUID = nodeGUID
+		conf.AppliedPortGUID = portGUID
 	} else if !conf.VfioPciMode {
 		// Verify VF have valid GUID (skip for VFIO as we can't access VF interface)
 		vfLink, err := s.nLink.LinkByName(conf.HostIFNames)
@@ -261,6 +591,76 @@ func (s *sriovManager) applyVFGuid(conf *types.NetConf, pfLink netlink.Link) err
 	return nil
 }
 
+// checkGUIDNotInUse checks that the guid requested for the VF is not the node or port GUID of another VF. A
+// GUID derived from the pod identity is reported as a collision of the derived GUID.
+func (s *sriovManager) checkGUIDNotInUse(conf *types.NetConf, guid string) error {
+	if guid == "" {
+		return nil
+	}
+	holder, err := FindGUIDHolder(s.nLink, guid, conf.DeviceID)
+	if err != nil {
+		return fmt.Errorf("failed to check if guid %s is in use: %v", guid, err)
+	}
+	if holder == nil {
+		return nil
+	}
+	if conf.GUIDMode == config.GUIDModeDerived && strings.EqualFold(guid, conf.GUID) {
+		return fmt.Errorf("derived GUID %s collides with VF %d (%s) of PF %s", guid, holder.VF, holder.PciAddress, holder.PF)
+	}
+	return fmt.Errorf("guid %s is already in use by VF %d (%s) of PF %s", guid, holder.VF, holder.PciAddress, holder.PF)
+}
+
+// hostGUIDToSet returns the original GUID of the VF to set. The all zeros GUID of a newly created VF is invalid,
+// the all-F GUID is set instead.
+func hostGUIDToSet(guid string) string {
+	if utils.IsAllZeroGUID(guid) {
+		return utils.DefaultGUID
+	}
+	return guid
+}
+
+// saveVfGUIDs saves the node and port GUIDs of the VF, read from the VF info of the PF, to restore them on
+// release. For drivers not reporting VF GUIDs, the port GUID of the VF netdevice is saved as both GUIDs, or the
+// all-F GUID for VFIO VFs which have no netdevice.
+func (s *sriovManager) saveVfGUIDs(conf *types.NetConf, pfLink netlink.Link) error {
+	vf, err := s.getVfGUIDs(pfLink, conf.VFID)
+	if err != nil {
+		return err
+	}
+	if vf != nil && vf.NodeGUID != "" && vf.PortGUID != "" {
+		conf.HostIFNodeGUID = vf.NodeGUID
+		conf.HostIFPortGUID = vf.PortGUID
+		return nil
+	}
+
+	if conf.VfioPciMode {
+		conf.HostIFNodeGUID = utils.DefaultGUID
+		conf.HostIFPortGUID = utils.DefaultGUID
+		return nil
+	}
+	vfLink, err := s.nLink.LinkByName(conf.HostIFNames)
+	if err != nil {
+		return fmt.Errorf("failed to lookup vf %q: %v", conf.HostIFNames, err)
+	}
+	conf.HostIFNodeGUID = utils.GetGUIDFromHwAddr(vfLink.Attrs().HardwareAddr)
+	conf.HostIFPortGUID = conf.HostIFNodeGUID
+	return nil
+}
+
+// getVfGUIDs returns the GUIDs of the VF from the VF info of the PF, or nil if the driver doesn't report them
+func (s *sriovManager) getVfGUIDs(pfLink netlink.Link, vfID int) (*types.VfGUIDs, error) {
+	vfs, err := s.nLink.LinkVfGUIDs(pfLink)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guids of vf %d: %v", vfID, err)
+	}
+	for i := range vfs {
+		if vfs[i].VF == vfID {
+			return &vfs[i], nil
+		}
+	}
+	return nil, nil
+}
+
 // ApplyVFConfig configure a VF with parameters given in NetConf
 func (s *sriovManager) ApplyVFConfig(conf *types.NetConf) error {
 	pfLink, err := s.nLink.LinkByName(conf.Master)
@@ -271,16 +671,10 @@ func (s *sriovManager) ApplyVFConfig(conf *types.NetConf) error {
 	// Set link state
 	if conf.LinkState != "" {
 		var state uint32
-		switch conf.LinkState {
-		case "auto":
-			state = netlink.VF_LINK_STATE_AUTO
-		case "enable":
-			state = netlink.VF_LINK_STATE_ENABLE
-		case "disable":
-			state = netlink.VF_LINK_STATE_DISABLE
-		default:
+		state, err = vfLinkState(conf.LinkState)
+		if err != nil {
 			// the value should have been validated earlier, return error if we somehow got here
-			return fmt.Errorf("unknown link state %s when setting it for vf %d: %v", conf.LinkState, conf.VFID, err)
+			return fmt.Errorf("%v when setting it for vf %d", err, conf.VFID)
 		}
 		if err = s.nLink.LinkSetVfState(pfLink, conf.VFID, state); err != nil {
 			return fmt.Errorf("failed to set vf %d link state to %d: %v", conf.VFID, state, err)
@@ -291,11 +685,124 @@ func (s *sriovManager) ApplyVFConfig(conf *types.NetConf) error {
 	return s.applyVFGuid(conf, pfLink)
 }
 
-// restoreVFName restores VF name from conf
-func (s *sriovManager) restoreVFName(conf *types.NetConf) error {
+// vfLinkState converts a link_state config value to its netlink VF link state
+func vfLinkState(linkState string) (uint32, error) {
+	switch linkState {
+	case "auto":
+		return netlink.VF_LINK_STATE_AUTO, nil
+	case "enable":
+		return netlink.VF_LINK_STATE_ENABLE, nil
+	case "disable":
+		return netlink.VF_LINK_STATE_DISABLE, nil
+	default:
+		return 0, fmt.Errorf("unknown link state %s", linkState)
+	}
+}
+
+// CheckVFConfig verifies that the VF state matches the configuration applied during ADD
+func (s *sriovManager) CheckVFConfig(conf *types.NetConf, podifName string, netns ns.NetNS) error {
+	pfLink, err := s.nLink.LinkByName(conf.Master)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+	}
+
+	if conf.LinkState != "" {
+		var expected uint32
+		expected, err = vfLinkState(conf.LinkState)
+		if err != nil {
+			return err
+		}
+		var vfInfo *netlink.VfInfo
+		for i := range pfLink.Attrs().Vfs {
+			if pfLink.Attrs().Vfs[i].ID == conf.VFID {
+				vfInfo = &pfLink.Attrs().Vfs[i]
+				break
+			}
+		}
+		if vfInfo == nil {
+			return fmt.Errorf("failed to find vf %d on master %q", conf.VFID, conf.Master)
+		}
+		if vfInfo.LinkState != expected {
+			return fmt.Errorf("vf %d link state is %d, expected %d (%s)",
+				conf.VFID, vfInfo.LinkState, expected, conf.LinkState)
+		}
+	}
+
+	vfGUIDs, err := s.checkVfGUIDs(conf, pfLink)
+	if err != nil {
+		return err
+	}
+
+	// VFIO devices don't have network interfaces to check
+	if conf.VfioPciMode {
+		return nil
+	}
+
+	return netns.Do(func(_ ns.NetNS) error {
+		linkObj, err := s.nLink.LinkByName(podifName)
+		if err != nil {
+			return fmt.Errorf("failed to get netlink device with name %s: %q", podifName, err)
+		}
+
+		if linkObj.Attrs().Flags&net.FlagUp == 0 {
+			return fmt.Errorf("interface %s is down", podifName)
+		}
+
+		// the hardware address carries the port GUID, checked if the driver doesn't report the VF GUIDs
+		if !vfGUIDs {
+			guid := utils.GetGUIDFromHwAddr(linkObj.Attrs().HardwareAddr)
+			if appliedGUIDDiffers(guid, conf.AppliedPortGUID) {
+				return fmt.Errorf("interface %s GUID is %q, expected %q", podifName, guid, conf.AppliedPortGUID)
+			}
+		}
+
+		if conf.MTU != 0 && linkObj.Attrs().MTU != conf.MTU {
+			return fmt.Errorf("interface %s mtu is %d, expected %d", podifName, linkObj.Attrs().MTU, conf.MTU)
+		}
+
+		return nil
+	})
+}
+
+// checkVfGUIDs checks the GUIDs set on the VF during ADD against the VF info of the PF. It returns false if
+// the driver doesn't report the VF GUIDs.
+func (s *sriovManager) checkVfGUIDs(conf *types.NetConf, pfLink netlink.Link) (bool, error) {
+	if conf.AppliedNodeGUID == "" && conf.AppliedPortGUID == "" {
+		return false, nil
+	}
+	vf, err := s.getVfGUIDs(pfLink, conf.VFID)
+	if err != nil {
+		return false, err
+	}
+	if vf == nil {
+		return false, nil
+	}
+
+	if appliedGUIDDiffers(vf.NodeGUID, conf.AppliedNodeGUID) {
+		return true, fmt.Errorf("vf %d node guid is %q, expected %q", conf.VFID, vf.NodeGUID, conf.AppliedNodeGUID)
+	}
+	if appliedGUIDDiffers(vf.PortGUID, conf.AppliedPortGUID) {
+		return true, fmt.Errorf("vf %d port guid is %q, expected %q", conf.VFID, vf.PortGUID, conf.AppliedPortGUID)
+	}
+	return true, nil
+}
+
+// appliedGUIDDiffers checks if a GUID read from the VF differs from the GUID set during ADD. The all-F guid
+// lets the driver pick the default guid of the VF, it is not checked.
+func appliedGUIDDiffers(readGUID, appliedGUID string) bool {
+	return appliedGUID != "" && !utils.IsAllOnesGUID(appliedGUID) && !strings.EqualFold(readGUID, appliedGUID)
+}
+
+// RestoreVFName restores VF name from conf
+func (s *sriovManager) RestoreVFName(conf *types.NetConf) error {
+	// VF had no known name, keep the one given by the kernel
+	if conf.HostIFNames == "" {
+		return nil
+	}
+
 	linkName, err := utils.GetVFLinkNames(conf.DeviceID)
 	if err != nil {
-		return fmt.Errorf("restoreVFName error: failed to get netdev name for VF %s, %v", conf.DeviceID, err)
+		return fmt.Errorf("RestoreVFName error: failed to get netdev name for VF %s, %v", conf.DeviceID, err)
 	}
 
 	if linkName == conf.HostIFNames {
@@ -306,12 +813,12 @@ func (s *sriovManager) restoreVFName(conf *types.NetConf) error {
 	var linkObj netlink.Link
 	linkObj, err = s.nLink.LinkByName(linkName)
 	if err != nil {
-		return fmt.Errorf("restoreVFName error: failed to get link for %s, %v", linkName, err)
+		return fmt.Errorf("RestoreVFName error: failed to get link for %s, %v", linkName, err)
 	}
 
 	err = s.nLink.LinkSetName(linkObj, conf.HostIFNames)
 	if err != nil {
-		return fmt.Errorf("restoreVFName error: failed to rename link %s to host name %s, %v",
+		return fmt.Errorf("RestoreVFName error: failed to rename link %s to host name %s, %v",
 			linkName, conf.HostIFNames, err)
 	}
 	return nil
@@ -335,48 +842,118 @@ func (s *sriovManager) ResetVFConfig(conf *types.NetConf) error {
 	}
 
 	// Reset link guid
-	// if the host guid is all zeros which is invalid guid replace it with all F guid
-	// This happen when create a VF it guid is all zeros
-	if conf.HostIFGUID != "" {
-		if utils.IsAllZeroGUID(conf.HostIFGUID) {
-			conf.HostIFGUID = utils.DefaultGUID
-		}
-
-		if err := s.setVfGUID(conf, pfLink, conf.HostIFGUID); err != nil {
+	nodeGUID, portGUID := conf.HostIFNodeGUID, conf.HostIFPortGUID
+	// attachments cached by older versions only saved the port GUID of the VF netdevice
+	if nodeGUID == "" && portGUID == "" {
+		nodeGUID, portGUID = conf.HostIFGUID, conf.HostIFGUID
+	}
+	if nodeGUID != "" && portGUID != "" {
+		if err := s.setVfGUID(conf, pfLink, hostGUIDToSet(nodeGUID), hostGUIDToSet(portGUID)); err != nil {
 			return err
 		}
-		// setVfGUID cause VF to rebind, which change its name. Lets restore it.
-		// For VFIO devices, skip VF name restoration since no rebind occurs
-		// Once setVfGUID wouldn't do rebind to apply GUID this function should be removed
-		if !conf.VfioPciMode {
-			return s.restoreVFName(conf)
-		}
 	}
 
-	return nil
+	// VFIO devices have no netdevice
+	if conf.VfioPciMode {
+		return nil
+	}
+	// setVfGUID may rebind the VF and a VF returned by a deleted pod netns is named by the kernel, which changes
+	// its name. Lets restore it.
+	if err = s.RestoreVFName(conf); err != nil {
+		return err
+	}
+	// The rebind resets the IPoIB mode and MTU, but it is skipped if the driver applies the GUID live and the VF of
+	// a deleted pod netns or of a rolled back ADD doesn't go through ReleaseVF. Restore them explicitly.
+	return s.restoreIPoIBConfig(conf)
 }
 
-func (s *sriovManager) setVfGUID(conf *types.NetConf, pfLink netlink.Link, guidAddr string) error {
-	guid, err := net.ParseMAC(guidAddr)
+func (s *sriovManager) setVfGUID(conf *types.NetConf, pfLink netlink.Link, nodeGUIDAddr, portGUIDAddr string) error {
+	nodeGUID, err := net.ParseMAC(nodeGUIDAddr)
+	if err != nil {
+		return fmt.Errorf("failed to parse guid %s: %v", nodeGUIDAddr, err)
+	}
+	portGUID, err := net.ParseMAC(portGUIDAddr)
 	if err != nil {
-		return fmt.Errorf("failed to parse guid %s: %v", guidAddr, err)
+		return fmt.Errorf("failed to parse guid %s: %v", portGUIDAddr, err)
 	}
-	err = s.nLink.LinkSetVfNodeGUID(pfLink, conf.VFID, guid)
+	err = s.nLink.LinkSetVfNodeGUID(pfLink, conf.VFID, nodeGUID)
 	if err != nil {
-		return fmt.Errorf("failed to add node guid %s: %v", guid, err)
+		return fmt.Errorf("failed to add node guid %s: %v", nodeGUID, err)
 	}
-	err = s.nLink.LinkSetVfPortGUID(pfLink, conf.VFID, guid)
+	err = s.nLink.LinkSetVfPortGUID(pfLink, conf.VFID, portGUID)
 	if err != nil {
-		return fmt.Errorf("failed to add port guid %s: %v", guid, err)
+		return fmt.Errorf("failed to add port guid %s: %v", portGUID, err)
 	}
 	// For VFIO devices, skip rebind as the device is bound to vfio-pci driver
 	// and doesn't have a network interface that can be unbound/rebound
 	if !conf.VfioPciMode {
-		// unbind vf then bind it to apply the guid
-		err = s.utils.RebindVf(conf.Master, conf.DeviceID)
-		if err != nil {
-			return err
+		// drivers applying the guid live don't need the slow rebind
+		if !s.isVfGUIDApplied(conf, nodeGUID, portGUID) {
+			// unbind vf then bind it to apply the guid
+			err = s.utils.RebindVf(conf.Master, conf.DeviceID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return s.verifyVfGUID(conf, pfLink, nodeGUID, portGUID)
+}
+
+// isGUIDMismatch checks if a GUID read back from the VF differs from the requested one. The all-F guid lets
+// the driver pick the default guid of the VF, it doesn't read back as set.
+func isGUIDMismatch(readGUID string, guid net.HardwareAddr) bool {
+	return !utils.IsAllOnesGUID(guid.String()) && !strings.EqualFold(readGUID, guid.String())
+}
+
+// verifyVfGUID checks that the guids read back from the VF info of the PF and, when the VF has a netdevice,
+// the port guid from its hardware address, as some firmware accepts the guids without applying them
+func (s *sriovManager) verifyVfGUID(conf *types.NetConf, pfLink netlink.Link, nodeGUID, portGUID net.HardwareAddr) error {
+	if utils.IsAllOnesGUID(nodeGUID.String()) && utils.IsAllOnesGUID(portGUID.String()) {
+		return nil
+	}
+
+	vf, err := s.getVfGUIDs(pfLink, conf.VFID)
+	if err != nil {
+		return err
+	}
+	if vf != nil {
+		if isGUIDMismatch(vf.NodeGUID, nodeGUID) {
+			return fmt.Errorf("vf %d node guid %s doesn't match the requested guid %s", conf.VFID, vf.NodeGUID, nodeGUID)
+		}
+		if isGUIDMismatch(vf.PortGUID, portGUID) {
+			return fmt.Errorf("vf %d port guid %s doesn't match the requested guid %s", conf.VFID, vf.PortGUID, portGUID)
 		}
 	}
+
+	if conf.VfioPciMode {
+		return nil
+	}
+	if linkGUID := s.getVfNetdevGUID(conf); linkGUID != "" && isGUIDMismatch(linkGUID, portGUID) {
+		return fmt.Errorf("vf %s netdevice guid %s doesn't match the requested guid %s", conf.DeviceID, linkGUID, portGUID)
+	}
 	return nil
 }
+
+// getVfNetdevGUID returns the port GUID in the hardware address of the VF netdevice, or an empty string
+// if the VF has no netdevice in the current namespace
+func (s *sriovManager) getVfNetdevGUID(conf *types.NetConf) string {
+	linkName, err := utils.GetVFLinkNames(conf.DeviceID)
+	if err != nil {
+		return ""
+	}
+	vfLink, err := s.nLink.LinkByName(linkName)
+	if err != nil {
+		return ""
+	}
+	return utils.GetGUIDFromHwAddr(vfLink.Attrs().HardwareAddr)
+}
+
+// isVfGUIDApplied checks if the driver applied the guids to the VF without a rebind, that is the port GUID
+// in the hardware address of the VF netdevice and the node GUID of the VF RDMA device read back as requested
+func (s *sriovManager) isVfGUIDApplied(conf *types.NetConf, nodeGUID, portGUID net.HardwareAddr) bool {
+	if !strings.EqualFold(s.getVfNetdevGUID(conf), portGUID.String()) {
+		return false
+	}
+	rdmaNodeGUID, err := utils.GetPciRdmaDevNodeGUID(conf.DeviceID)
+	return err == nil && strings.EqualFold(rdmaNodeGUID, nodeGUID.String())
+}
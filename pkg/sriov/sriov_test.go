@@ -3,15 +3,21 @@ package sriov
 import (
 	"errors"
 	"net"
+	"os"
+	"path/filepath"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/config"
 	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
 	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types/mocks"
+	"github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/utils"
 )
 
 // FakeLink is a dummy netlink struct used during testing
@@ -92,17 +98,78 @@ var _ = Describe("Sriov", func() {
 				HardwareAddr: gid,
 			}}
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			newGid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + netconf.GUID)
+			Expect(err).ToNot(HaveOccurred())
 
 			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return(nil, nil)
 			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
 			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
 
-			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+			// the rebind applies the GUID to the VF netdevice
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil).Run(
+				func(_ mock.Arguments) { fakeLink.HardwareAddr = newGid })
 
 			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
 			err = sm.ApplyVFConfig(netconf)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(netconf.HostIFGUID).To(Equal(hostGUID))
+			Expect(netconf.HostIFPortGUID).To(Equal(hostGUID))
+		})
+		It("ApplyVFConfig with GUID not applied by the firmware", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			hostGUID := "11:22:33:00:00:aa:bb:cc"
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGUID)
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: hostGUID, PortGUID: hostGUID},
+			}, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("vf 0 node guid 11:22:33:00:00:aa:bb:cc doesn't match the requested guid " +
+				"01:23:45:67:89:ab:cd:ef"))
+			// the original GUID is kept to roll back
+			Expect(netconf.HostIFPortGUID).To(Equal(hostGUID))
+		})
+		It("ApplyVFConfig with GUID not applied to the VF netdevice", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			hostGUID := "11:22:33:00:00:aa:bb:cc"
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGUID)
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return(nil, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("vf 0000:af:06.0 netdevice guid 11:22:33:00:00:aa:bb:cc " +
+				"doesn't match the requested guid 01:23:45:67:89:ab:cd:ef"))
 		})
 		It("ApplyVFConfig without GUID, VF's GUID all zeroes", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
@@ -213,6 +280,8 @@ var _ = Describe("Sriov", func() {
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
 
 			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return(nil, nil)
 			mockedNetLinkManger.On("LinkByName", netconf.HostIFNames).Return(nil, errors.New("mocked failed"))
 
 			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
@@ -234,6 +303,7 @@ var _ = Describe("Sriov", func() {
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
 
 			mockedNetLinkManger.On("LinkByName", mock.Anything).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return(nil, nil)
 			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.Anything, mock.Anything).Return(
 				errors.New("mocked failed"))
 
@@ -241,7 +311,7 @@ var _ = Describe("Sriov", func() {
 			err = sm.ApplyVFConfig(netconf)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal(`failed to add node guid 01:23:45:67:89:ab:cd:ef: mocked failed`))
-			Expect(netconf.HostIFGUID).To(Equal(hostGUID))
+			Expect(netconf.HostIFPortGUID).To(Equal(hostGUID))
 		})
 		It("ApplyVFConfig check guid - failed to set port guid", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
@@ -257,6 +327,7 @@ var _ = Describe("Sriov", func() {
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
 
 			mockedNetLinkManger.On("LinkByName", mock.Anything).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return(nil, nil)
 			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.Anything, mock.Anything).Return(nil)
 			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.Anything, mock.Anything).Return(
 				errors.New("mocked failed"))
@@ -265,7 +336,7 @@ var _ = Describe("Sriov", func() {
 			err = sm.ApplyVFConfig(netconf)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal(`failed to add port guid 01:23:45:67:89:ab:cd:ef: mocked failed`))
-			Expect(netconf.HostIFGUID).To(Equal(hostGUID))
+			Expect(netconf.HostIFPortGUID).To(Equal(hostGUID))
 		})
 		It("ApplyVFConfig check guid - failed to rebind after set guid", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
@@ -282,6 +353,7 @@ var _ = Describe("Sriov", func() {
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
 
 			mockedNetLinkManger.On("LinkByName", mock.Anything).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return(nil, nil)
 			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.Anything, mock.Anything).Return(nil)
 			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.Anything, mock.Anything).Return(nil)
 
@@ -291,7 +363,248 @@ var _ = Describe("Sriov", func() {
 			err = sm.ApplyVFConfig(netconf)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal("mocked failed"))
-			Expect(netconf.HostIFGUID).To(Equal(hostGUID))
+			Expect(netconf.HostIFPortGUID).To(Equal(hostGUID))
+		})
+		It("ApplyVFConfig with GUID applied live by the driver - should skip rebind", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			// the VF netdevice and RDMA device of 0000:af:06.0 report the GUID once it is set
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:00:02:c9:03:00:00:00:01")
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "00:02:c9:03:00:00:00:01"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return(nil, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedPciUtils.AssertNotCalled(GinkgoT(), "RebindVf", mock.Anything, mock.Anything)
+		})
+		It("ApplyVFConfig with GUID in use by another VF", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			hostGUID := "11:22:33:00:00:aa:bb:cc"
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGUID)
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 1, NodeGUID: "01:23:45:67:89:AB:CD:EF", PortGUID: "01:23:45:67:89:AB:CD:EF"},
+			}, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("guid 01:23:45:67:89:ab:cd:ef is already in use by VF 1 (0000:af:06.1) of PF ib0"))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfNodeGUID", mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("ApplyVFConfig with derived GUID in use by another VF", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.GUIDMode = config.GUIDModeDerived
+			netconf.GUID = "02:00:00:12:34:56:78:9a"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 1, NodeGUID: "02:00:00:12:34:56:78:9a", PortGUID: "02:00:00:12:34:56:78:9a"},
+			}, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("derived GUID 02:00:00:12:34:56:78:9a collides with VF 1 (0000:af:06.1) of PF ib0"))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfNodeGUID", mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("ApplyVFConfig saves the original GUIDs from the VF info of the PF", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.VfioPciMode = true
+			netconf.HostIFNames = ""
+
+			vfGUIDs := []types.VfGUIDs{{VF: 0, NodeGUID: "02:00:00:00:00:00:10:01", PortGUID: "02:00:00:00:00:00:20:01"}}
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			// the duplicate GUID check and the save of the original GUIDs list the VF GUIDs before the GUID is set
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return(vfGUIDs, nil).Twice()
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			// the GUID reads back as requested
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: netconf.GUID, PortGUID: netconf.GUID},
+			}, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostIFNodeGUID).To(Equal("02:00:00:00:00:00:10:01"))
+			Expect(netconf.HostIFPortGUID).To(Equal("02:00:00:00:00:00:20:01"))
+		})
+		It("ApplyVFConfig with separate node and port GUIDs", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.VfioPciMode = true
+			netconf.HostIFNames = ""
+			netconf.NodeGUID = "02:00:00:00:00:00:10:01"
+			netconf.RuntimeConfig.PortGUID = "02:00:00:00:00:00:20:01"
+			nodeGUID, err := net.ParseMAC(netconf.NodeGUID)
+			Expect(err).ToNot(HaveOccurred())
+			portGUID, err := net.ParseMAC(netconf.RuntimeConfig.PortGUID)
+			Expect(err).ToNot(HaveOccurred())
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: "02:00:00:00:00:00:10:00", PortGUID: "02:00:00:00:00:00:20:00"},
+			}, nil).Twice()
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, netconf.VFID, nodeGUID).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, netconf.VFID, portGUID).Return(nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: netconf.NodeGUID, PortGUID: netconf.RuntimeConfig.PortGUID},
+			}, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostIFNodeGUID).To(Equal("02:00:00:00:00:00:10:00"))
+			Expect(netconf.HostIFPortGUID).To(Equal("02:00:00:00:00:00:20:00"))
+			Expect(netconf.AppliedNodeGUID).To(Equal("02:00:00:00:00:00:10:01"))
+			Expect(netconf.AppliedPortGUID).To(Equal("02:00:00:00:00:00:20:01"))
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ApplyVFConfig with node GUID only keeps the original port GUID", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.VfioPciMode = true
+			netconf.HostIFNames = ""
+			netconf.NodeGUID = "02:00:00:00:00:00:10:01"
+			nodeGUID, err := net.ParseMAC(netconf.NodeGUID)
+			Expect(err).ToNot(HaveOccurred())
+			origPortGUID, err := net.ParseMAC("02:00:00:00:00:00:20:00")
+			Expect(err).ToNot(HaveOccurred())
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: "02:00:00:00:00:00:10:00", PortGUID: origPortGUID.String()},
+			}, nil).Once()
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, netconf.VFID, nodeGUID).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, netconf.VFID, origPortGUID).Return(nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: netconf.NodeGUID, PortGUID: origPortGUID.String()},
+			}, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ApplyVFConfig with node GUID configured for the VFs of the site", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.VfioPciMode = true
+			netconf.HostIFNames = ""
+			netconf.NodeGUID = "02:00:00:00:00:00:10:01"
+			netconf.GUID = "02:00:00:00:00:00:20:01"
+			nodeGUID, err := net.ParseMAC(netconf.NodeGUID)
+			Expect(err).ToNot(HaveOccurred())
+			portGUID, err := net.ParseMAC(netconf.GUID)
+			Expect(err).ToNot(HaveOccurred())
+
+			// VF 1 already holds the node GUID
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: "02:00:00:00:00:00:10:00", PortGUID: "02:00:00:00:00:00:20:00"},
+				{VF: 1, NodeGUID: netconf.NodeGUID, PortGUID: "02:00:00:00:00:00:20:02"},
+			}, nil).Twice()
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, netconf.VFID, nodeGUID).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, netconf.VFID, portGUID).Return(nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: netconf.NodeGUID, PortGUID: netconf.GUID},
+				{VF: 1, NodeGUID: netconf.NodeGUID, PortGUID: "02:00:00:00:00:00:20:02"},
+			}, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ApplyVFConfig with port GUID in use by another VF sharing the node GUID", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.VfioPciMode = true
+			netconf.HostIFNames = ""
+			netconf.NodeGUID = "02:00:00:00:00:00:10:01"
+			netconf.GUID = "02:00:00:00:00:00:20:02"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 1, NodeGUID: netconf.NodeGUID, PortGUID: "02:00:00:00:00:00:20:02"},
+			}, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("guid 02:00:00:00:00:00:20:02 is already in use by VF 1 (0000:af:06.1) of PF ib0"))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfNodeGUID", mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("ApplyVFConfig with runtime config node GUID in use by another VF", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.VfioPciMode = true
+			netconf.HostIFNames = ""
+			netconf.RuntimeConfig.NodeGUID = "02:00:00:00:00:00:10:01"
+			netconf.GUID = "02:00:00:00:00:00:20:01"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 1, NodeGUID: netconf.RuntimeConfig.NodeGUID, PortGUID: "02:00:00:00:00:00:20:02"},
+			}, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("guid 02:00:00:00:00:00:10:01 is already in use by VF 1 (0000:af:06.1) of PF ib0"))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfNodeGUID", mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("ApplyVFConfig with invalid port GUID", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.RuntimeConfig.PortGUID = "00:00:00:00:00:00:00:00"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger}
+			err := sm.ApplyVFConfig(netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("invalid port guid 00:00:00:00:00:00:00:00"))
 		})
 		It("ApplyVFConfig with valid GUID and VfioPciMode VF (no network interface) - should return success after setting GUID", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
@@ -302,8 +615,10 @@ var _ = Describe("Sriov", func() {
 			netconf.VfioPciMode = true
 			netconf.HostIFNames = "" // VFIO VF has no network interface
 
-			// Only PF link is needed for VFIO VF
+			// Only PF links are needed for VFIO VF
 			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return(nil, nil)
 			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
 			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
 
@@ -312,8 +627,9 @@ var _ = Describe("Sriov", func() {
 			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
 			err := sm.ApplyVFConfig(netconf)
 			Expect(err).NotTo(HaveOccurred())
-			// For VFIO VF, HostIFGUID should be set to all-F for reset during deletion
-			Expect(netconf.HostIFGUID).To(Equal("FF:FF:FF:FF:FF:FF:FF:FF"))
+			// For VFIO VF whose driver doesn't report VF GUIDs, all-F is saved for reset during deletion
+			Expect(netconf.HostIFNodeGUID).To(Equal("FF:FF:FF:FF:FF:FF:FF:FF"))
+			Expect(netconf.HostIFPortGUID).To(Equal("FF:FF:FF:FF:FF:FF:FF:FF"))
 		})
 	})
 	Context("Checking SetupVF function", func() {
@@ -432,6 +748,110 @@ var _ = Describe("Sriov", func() {
 			err := sm.SetupVF(netconf, podifName, contID, targetNetNS)
 			Expect(err).NotTo(HaveOccurred())
 		})
+		It("Set IPoIB mode and mtu", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.IPoIBMode = "connected"
+			netconf.MTU = 65520
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "ib1",
+				MTU:   2044,
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetMTU", fakeLink, 65520).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.SetupVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostIFIPoIBMode).To(Equal("datagram"))
+			Expect(netconf.HostIFMTU).To(Equal(2044))
+			Expect(utils.GetIPoIBMode("ib1")).To(Equal("connected"))
+			mocked.AssertExpectations(GinkgoT())
+
+			Expect(utils.SetIPoIBMode("ib1", "datagram")).To(Succeed())
+		})
+		It("Set mtu not allowed by the current IPoIB mode", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.MTU = 9000
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "ib1",
+				MTU:   2044,
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.SetupVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetMTU", mock.Anything, mock.Anything)
+		})
+		It("Create IPoIB child of the pkey", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.PKey = "0x8005"
+			netconf.IPoIBChildPKey = true
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			childLink := &FakeLink{netlink.LinkAttrs{
+				Index:       1001,
+				Name:        podifName,
+				ParentIndex: 1000,
+			}}
+
+			mocked.On("LinkByName", podifName).Return(childLink, nil)
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, "vfdev1000").Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			mocked.On("LinkAdd", mock.MatchedBy(func(link *netlink.IPoIB) bool {
+				return link.Name == podifName && link.ParentIndex == 1000 && link.Pkey == 0x8005
+			})).Return(nil)
+			mocked.On("LinkSetUp", childLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.SetupVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.ContIFNames).To(Equal(podifName))
+			Expect(netconf.ContParentIFName).To(Equal("vfdev1000"))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Failed to create IPoIB child of the pkey", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.PKey = "0x8005"
+			netconf.IPoIBChildPKey = true
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, "vfdev1000").Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			mocked.On("LinkAdd", mock.Anything).Return(errors.New("failed"))
+			// VF is given the Pod IF name so that it can be released
+			mocked.On("LinkSetName", fakeLink, podifName).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.SetupVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
 	})
 	Context("Checking ReleaseVF function", func() {
 		var (
@@ -511,6 +931,82 @@ var _ = Describe("Sriov", func() {
 			err := sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
 			Expect(err).To(HaveOccurred())
 		})
+		It("Assuming IPoIB mode and mtu were set", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.HostIFIPoIBMode = "connected"
+			netconf.HostIFMTU = 65520
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: podifName}}
+			hostLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "ib1"}}
+
+			mocked.On("LinkByName", podifName).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, "ib1").Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkByName", "ib1").Return(hostLink, nil)
+			mocked.On("LinkSetMTU", hostLink, 65520).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(utils.GetIPoIBMode("ib1")).To(Equal("connected"))
+			mocked.AssertExpectations(GinkgoT())
+
+			Expect(utils.SetIPoIBMode("ib1", "datagram")).To(Succeed())
+		})
+		It("Assuming IPoIB child of the pkey", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.PKey = "0x8005"
+			netconf.IPoIBChildPKey = true
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "vfdev1000"}}
+			childLink := &FakeLink{netlink.LinkAttrs{Index: 1001, Name: podifName, ParentIndex: 1000}}
+
+			mocked.On("LinkByName", podifName).Return(childLink, nil)
+			mocked.On("LinkByIndex", 1000).Return(fakeLink, nil)
+			mocked.On("LinkDel", childLink).Return(nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, netconf.HostIFNames).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming IPoIB child of the pkey already deleted", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.PKey = "0x8005"
+			netconf.IPoIBChildPKey = true
+			netconf.ContParentIFName = "vfdev1000"
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "vfdev1000"}}
+
+			mocked.On("LinkByName", podifName).Return(nil, netlink.LinkNotFoundError{})
+			mocked.On("LinkByName", "vfdev1000").Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, netconf.HostIFNames).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+			mocked.AssertNotCalled(GinkgoT(), "LinkDel", mock.Anything)
+		})
+		It("Assuming IPoIB child of the pkey was not created", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.PKey = "0x8005"
+			netconf.IPoIBChildPKey = true
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: podifName}}
+
+			mocked.On("LinkByName", podifName).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, netconf.HostIFNames).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "LinkDel", mock.Anything)
+		})
 		It("Assuming failed to set interface up after moving", func() {
 			targetNetNS := newFakeNs()
 			mocked := &mocks.NetlinkManager{}
@@ -557,6 +1053,9 @@ var _ = Describe("Sriov", func() {
 			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
 			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
 			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: netconf.HostIFGUID, PortGUID: netconf.HostIFGUID},
+			}, nil)
 
 			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
 
@@ -570,19 +1069,91 @@ var _ = Describe("Sriov", func() {
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{}}
 			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			// the VF returned by a deleted pod netns is named by the kernel
+			mockedNetLinkManger.On("LinkSetName", fakeLink, netconf.HostIFNames).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ResetVFConfig restores the IPoIB mode and MTU", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			modeFile := filepath.Join(utils.NetDirectory, "ib1", "mode")
+			Expect(os.WriteFile(modeFile, []byte("connected\n"), 0o600)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(os.WriteFile(modeFile, []byte("datagram\n"), 0o600)).To(Succeed())
+			})
+			netconf.HostIFNames = "ib1"
+			netconf.HostIFIPoIBMode = utils.IPoIBModeDatagram
+			netconf.HostIFMTU = 2044
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetMTU", fakeLink, 2044).Return(nil)
 
 			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
 			err := sm.ResetVFConfig(netconf)
 			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+			Expect(utils.GetIPoIBMode("ib1")).To(Equal(utils.IPoIBModeDatagram))
 		})
 		It("ResetVFConfig with GUID all zeros", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
 			mockedPciUtils := &mocks.PciUtils{}
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{}}
-			netconf.HostIFGUID = "00:00:00:00:00:00:00:00"
+			netconf.HostIFNodeGUID = "00:00:00:00:00:00:00:00"
+			netconf.HostIFPortGUID = "00:00:00:00:00:00:00:00"
+			defaultGUID, err := net.ParseMAC(utils.DefaultGUID)
+			Expect(err).ToNot(HaveOccurred())
 
 			mockedNetLinkManger.On("LinkSetName", fakeLink, netconf.HostIFNames).Return(nil)
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, netconf.VFID, defaultGUID).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, netconf.VFID, defaultGUID).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("ResetVFConfig restores the original node and port GUIDs", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.VfioPciMode = true
+			netconf.HostIFNodeGUID = "02:00:00:00:00:00:10:01"
+			netconf.HostIFPortGUID = "02:00:00:00:00:00:20:01"
+			nodeGUID, err := net.ParseMAC(netconf.HostIFNodeGUID)
+			Expect(err).ToNot(HaveOccurred())
+			portGUID, err := net.ParseMAC(netconf.HostIFPortGUID)
+			Expect(err).ToNot(HaveOccurred())
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, netconf.VFID, nodeGUID).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, netconf.VFID, portGUID).Return(nil)
+			mockedNetLinkManger.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: netconf.HostIFNodeGUID, PortGUID: netconf.HostIFPortGUID},
+			}, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ResetVFConfig with unknown VF name", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "FF:FF:FF:FF:FF:FF:FF:FF"
+			netconf.HostIFNames = ""
+
 			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
 			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
 			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
@@ -592,7 +1163,7 @@ var _ = Describe("Sriov", func() {
 			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
 			err := sm.ResetVFConfig(netconf)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(netconf.HostIFGUID).To(Equal("FF:FF:FF:FF:FF:FF:FF:FF"))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetName", mock.Anything, mock.Anything)
 		})
 		It("ResetVFConfig with invalid GUID", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
@@ -608,4 +1179,214 @@ var _ = Describe("Sriov", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+	Context("Checking CheckVFConfig function", func() {
+		var (
+			podifName string
+			netconf   *types.NetConf
+		)
+
+		BeforeEach(func() {
+			podifName = "net1"
+			netconf = &types.NetConf{
+				IbSriovNetConf: types.IbSriovNetConf{
+					Master:          "ib0",
+					DeviceID:        "0000:af:06.0",
+					VFID:            0,
+					HostIFNames:     "ib1",
+					ContIFNames:     "net1",
+					AppliedNodeGUID: "01:23:45:67:89:ab:cd:ef",
+					AppliedPortGUID: "01:23:45:67:89:ab:cd:ef",
+					LinkState:       "enable",
+				},
+			}
+		})
+
+		It("Assuming VF matches configuration", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + netconf.AppliedPortGUID)
+			Expect(err).ToNot(HaveOccurred())
+
+			pfLink := &FakeLink{netlink.LinkAttrs{
+				Vfs: []netlink.VfInfo{{ID: 0, LinkState: netlink.VF_LINK_STATE_ENABLE}},
+			}}
+			podLink := &FakeLink{netlink.LinkAttrs{Flags: net.FlagUp, HardwareAddr: gid}}
+
+			mocked.On("LinkByName", netconf.Master).Return(pfLink, nil)
+			mocked.On("LinkVfGUIDs", pfLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: netconf.AppliedNodeGUID, PortGUID: netconf.AppliedPortGUID},
+			}, nil)
+			mocked.On("LinkByName", podifName).Return(podLink, nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.CheckVFConfig(netconf, podifName, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming VF link state mismatch", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+
+			pfLink := &FakeLink{netlink.LinkAttrs{
+				Vfs: []netlink.VfInfo{{ID: 0, LinkState: netlink.VF_LINK_STATE_DISABLE}},
+			}}
+
+			mocked.On("LinkByName", netconf.Master).Return(pfLink, nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.CheckVFConfig(netconf, podifName, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("link state"))
+		})
+		It("Assuming pod interface is down", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.LinkState = ""
+
+			podLink := &FakeLink{netlink.LinkAttrs{}}
+
+			mocked.On("LinkByName", netconf.Master).Return(&FakeLink{netlink.LinkAttrs{}}, nil)
+			mocked.On("LinkVfGUIDs", mock.Anything).Return(nil, nil)
+			mocked.On("LinkByName", podifName).Return(podLink, nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.CheckVFConfig(netconf, podifName, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("interface net1 is down"))
+		})
+		It("Assuming VF GUID mismatch in the VF info of the PF", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.LinkState = ""
+
+			pfLink := &FakeLink{netlink.LinkAttrs{}}
+			mocked.On("LinkByName", netconf.Master).Return(pfLink, nil)
+			mocked.On("LinkVfGUIDs", pfLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: netconf.AppliedNodeGUID, PortGUID: "11:22:33:00:00:aa:bb:cc"},
+			}, nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.CheckVFConfig(netconf, podifName, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`vf 0 port guid is "11:22:33:00:00:aa:bb:cc", expected "01:23:45:67:89:ab:cd:ef"`))
+		})
+		It("Assuming pod interface GUID mismatch", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.LinkState = ""
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
+
+			podLink := &FakeLink{netlink.LinkAttrs{Flags: net.FlagUp, HardwareAddr: gid}}
+
+			// the driver doesn't report the VF GUIDs, the pod interface hardware address is checked
+			mocked.On("LinkByName", netconf.Master).Return(&FakeLink{netlink.LinkAttrs{}}, nil)
+			mocked.On("LinkVfGUIDs", mock.Anything).Return(nil, nil)
+			mocked.On("LinkByName", podifName).Return(podLink, nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.CheckVFConfig(netconf, podifName, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("GUID"))
+		})
+		It("Assuming pod interface is missing", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.LinkState = ""
+
+			mocked.On("LinkByName", netconf.Master).Return(&FakeLink{netlink.LinkAttrs{}}, nil)
+			mocked.On("LinkVfGUIDs", mock.Anything).Return(nil, nil)
+			mocked.On("LinkByName", podifName).Return(nil, errors.New("not found"))
+			sm := sriovManager{nLink: mocked}
+			err := sm.CheckVFConfig(netconf, podifName, targetNetNS)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming VfioPciMode VF skips interface checks", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.VfioPciMode = true
+
+			pfLink := &FakeLink{netlink.LinkAttrs{
+				Vfs: []netlink.VfInfo{{ID: 0, LinkState: netlink.VF_LINK_STATE_ENABLE}},
+			}}
+
+			mocked.On("LinkByName", netconf.Master).Return(pfLink, nil)
+			mocked.On("LinkVfGUIDs", pfLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: netconf.AppliedNodeGUID, PortGUID: netconf.AppliedPortGUID},
+			}, nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.CheckVFConfig(netconf, podifName, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "LinkByName", podifName)
+		})
+		It("Assuming VfioPciMode VF GUID mismatch", func() {
+			targetNetNS := newFakeNs()
+			mocked := &mocks.NetlinkManager{}
+			netconf.VfioPciMode = true
+			netconf.LinkState = ""
+
+			pfLink := &FakeLink{netlink.LinkAttrs{}}
+			mocked.On("LinkByName", netconf.Master).Return(pfLink, nil)
+			mocked.On("LinkVfGUIDs", pfLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: "11:22:33:00:00:aa:bb:cc", PortGUID: netconf.AppliedPortGUID},
+			}, nil)
+			sm := sriovManager{nLink: mocked}
+			err := sm.CheckVFConfig(netconf, podifName, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("vf 0 node guid"))
+		})
+	})
+	Context("Checking parseVfGUIDs function", func() {
+		It("Parses the node and port GUIDs of the VFs", func() {
+			vfList := nl.NewRtAttr(unix.IFLA_VFINFO_LIST, nil)
+			vf0 := vfList.AddRtAttr(nl.IFLA_VF_INFO, nil)
+			vf0.AddRtAttr(nl.IFLA_VF_IB_NODE_GUID, (&nl.VfGUID{Vf: 0, GUID: 0x0011223344556677}).Serialize())
+			vf0.AddRtAttr(nl.IFLA_VF_IB_PORT_GUID, (&nl.VfGUID{Vf: 0, GUID: 0x0011223344556678}).Serialize())
+			// VF without GUID attributes
+			vf1 := vfList.AddRtAttr(nl.IFLA_VF_INFO, nil)
+			vf1.AddRtAttr(nl.IFLA_VF_MAC, make([]byte, nl.SizeofVfMac))
+
+			vfs, err := parseVfGUIDs(vfList.Serialize())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vfs).To(Equal([]types.VfGUIDs{
+				{VF: 0, NodeGUID: "00:11:22:33:44:55:66:77", PortGUID: "00:11:22:33:44:55:66:78"},
+			}))
+		})
+		It("Returns no VFs without VF info list", func() {
+			vfs, err := parseVfGUIDs(nl.NewRtAttr(unix.IFLA_MTU, nl.Uint32Attr(2044)).Serialize())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vfs).To(BeEmpty())
+		})
+	})
+
+	Context("Checking FindGUIDHolder function", func() {
+		It("Returns the VF holding the GUID", func() {
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Name: "ib0"}}
+			mocked.On("LinkByName", "ib0").Return(fakeLink, nil)
+			mocked.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: "00:11:22:33:44:55:66:77", PortGUID: "00:11:22:33:44:55:66:77"},
+				{VF: 1, NodeGUID: "00:11:22:33:44:55:66:88", PortGUID: "00:11:22:33:44:55:66:88"},
+			}, nil)
+
+			holder, err := FindGUIDHolder(mocked, "00:11:22:33:44:55:66:88", "0000:af:06.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holder).To(Equal(&GUIDHolder{PF: "ib0", VF: 1, PciAddress: "0000:af:06.1"}))
+		})
+		It("Ignores the VF of the attachment", func() {
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Name: "ib0"}}
+			mocked.On("LinkByName", "ib0").Return(fakeLink, nil)
+			mocked.On("LinkVfGUIDs", fakeLink).Return([]types.VfGUIDs{
+				{VF: 0, NodeGUID: "00:11:22:33:44:55:66:77", PortGUID: "00:11:22:33:44:55:66:77"},
+			}, nil)
+
+			holder, err := FindGUIDHolder(mocked, "00:11:22:33:44:55:66:77", "0000:af:06.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(holder).To(BeNil())
+		})
+		It("Fails when the VF GUIDs can't be listed", func() {
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Name: "ib0"}}
+			mocked.On("LinkByName", "ib0").Return(fakeLink, nil)
+			mocked.On("LinkVfGUIDs", fakeLink).Return(nil, errors.New("netlink error"))
+
+			_, err := FindGUIDHolder(mocked, "00:11:22:33:44:55:66:77", "0000:af:06.0")
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
@@ -31,6 +31,24 @@ func (_m *Manager) ApplyVFConfig(conf *types.NetConf) error {
 	return r0
 }
 
+// CheckVFConfig provides a mock function with given fields: conf, podifName, netns
+func (_m *Manager) CheckVFConfig(conf *types.NetConf, podifName string, netns ns.NetNS) error {
+	ret := _m.Called(conf, podifName, netns)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CheckVFConfig")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*types.NetConf, string, ns.NetNS) error); ok {
+		r0 = rf(conf, podifName, netns)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // ReleaseVF provides a mock function with given fields: conf, podifName, cid, netns
 func (_m *Manager) ReleaseVF(conf *types.NetConf, podifName string, cid string, netns ns.NetNS) error {
 	ret := _m.Called(conf, podifName, cid, netns)
@@ -67,6 +85,24 @@ func (_m *Manager) ResetVFConfig(conf *types.NetConf) error {
 	return r0
 }
 
+// RestoreVFName provides a mock function with given fields: conf
+func (_m *Manager) RestoreVFName(conf *types.NetConf) error {
+	ret := _m.Called(conf)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RestoreVFName")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*types.NetConf) error); ok {
+		r0 = rf(conf)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // SetupVF provides a mock function with given fields: conf, podifName, cid, netns
 func (_m *Manager) SetupVF(conf *types.NetConf, podifName string, cid string, netns ns.NetNS) error {
 	ret := _m.Called(conf, podifName, cid, netns)
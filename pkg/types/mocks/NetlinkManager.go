@@ -8,6 +8,8 @@ import (
 	mock "github.com/stretchr/testify/mock"
 
 	netlink "github.com/vishvananda/netlink"
+
+	types "github.com/k8snetworkplumbingwg/ib-sriov-cni/pkg/types"
 )
 
 // NetlinkManager is an autogenerated mock type for the NetlinkManager type
@@ -15,6 +17,54 @@ type NetlinkManager struct {
 	mock.Mock
 }
 
+// LinkAdd provides a mock function with given fields: _a0
+func (_m *NetlinkManager) LinkAdd(_a0 netlink.Link) error {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LinkAdd")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(netlink.Link) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// LinkByIndex provides a mock function with given fields: _a0
+func (_m *NetlinkManager) LinkByIndex(_a0 int) (netlink.Link, error) {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LinkByIndex")
+	}
+
+	var r0 netlink.Link
+	var r1 error
+	if rf, ok := ret.Get(0).(func(int) (netlink.Link, error)); ok {
+		return rf(_a0)
+	}
+	if rf, ok := ret.Get(0).(func(int) netlink.Link); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(netlink.Link)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(int) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // LinkByName provides a mock function with given fields: _a0
 func (_m *NetlinkManager) LinkByName(_a0 string) (netlink.Link, error) {
 	ret := _m.Called(_a0)
@@ -45,6 +95,24 @@ func (_m *NetlinkManager) LinkByName(_a0 string) (netlink.Link, error) {
 	return r0, r1
 }
 
+// LinkDel provides a mock function with given fields: _a0
+func (_m *NetlinkManager) LinkDel(_a0 netlink.Link) error {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LinkDel")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(netlink.Link) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // LinkDelAltName provides a mock function with given fields: _a0, _a1
 func (_m *NetlinkManager) LinkDelAltName(_a0 netlink.Link, _a1 string) error {
 	ret := _m.Called(_a0, _a1)
@@ -81,6 +149,24 @@ func (_m *NetlinkManager) LinkSetDown(_a0 netlink.Link) error {
 	return r0
 }
 
+// LinkSetMTU provides a mock function with given fields: _a0, _a1
+func (_m *NetlinkManager) LinkSetMTU(_a0 netlink.Link, _a1 int) error {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LinkSetMTU")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(netlink.Link, int) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // LinkSetName provides a mock function with given fields: _a0, _a1
 func (_m *NetlinkManager) LinkSetName(_a0 netlink.Link, _a1 string) error {
 	ret := _m.Called(_a0, _a1)
@@ -189,6 +275,36 @@ func (_m *NetlinkManager) LinkSetVfState(_a0 netlink.Link, _a1 int, _a2 uint32)
 	return r0
 }
 
+// LinkVfGUIDs provides a mock function with given fields: _a0
+func (_m *NetlinkManager) LinkVfGUIDs(_a0 netlink.Link) ([]types.VfGUIDs, error) {
+	ret := _m.Called(_a0)
+
+	if len(ret) == 0 {
+		panic("no return value specified for LinkVfGUIDs")
+	}
+
+	var r0 []types.VfGUIDs
+	var r1 error
+	if rf, ok := ret.Get(0).(func(netlink.Link) ([]types.VfGUIDs, error)); ok {
+		return rf(_a0)
+	}
+	if rf, ok := ret.Get(0).(func(netlink.Link) []types.VfGUIDs); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]types.VfGUIDs)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(netlink.Link) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewNetlinkManager creates a new instance of NetlinkManager. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewNetlinkManager(t interface {
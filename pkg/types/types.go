@@ -6,6 +6,7 @@ import (
 	"net"
 
 	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/vishvananda/netlink"
 
@@ -22,16 +23,49 @@ type IbSriovNetConf struct {
 	Master              string
 	DeviceID            string `json:"deviceID"` // PCI address of a VF in valid sysfs format
 	VFID                int
-	HostIFNames         string // VF netdevice name(s)
-	HostIFGUID          string // VF netdevice GUID
-	ContIFNames         string // VF names after in the container; used during deletion
-	GUID                string `json:"-"` // Taken from either CNI_ARGS "guid" attribute or from RuntimeConfig
-	PKey                string `json:"pkey"`
-	LinkState           string `json:"link_state,omitempty"` // auto|enable|disable
-	RdmaIsolation       bool   `json:"rdmaIsolation,omitempty"`
-	IBKubernetesEnabled bool   `json:"ibKubernetesEnabled,omitempty"`
-	VfioPciMode         bool   `json:"vfioPciMode,omitempty"` // Skip SR-IOV network setup, default false
-	IsVFDevice          bool   `json:"-"`                     // Runtime flag: true if device is VF, false if PF
+	HostIFNames         string          // VF netdevice name(s)
+	HostIFGUID          string          // VF netdevice GUID; cached by older versions, superseded by HostIFNodeGUID/HostIFPortGUID
+	HostIFNodeGUID      string          // VF node GUID before guid was applied
+	HostIFPortGUID      string          // VF port GUID before guid was applied
+	AppliedNodeGUID     string          // VF node GUID set during ADD; used by CHECK
+	AppliedPortGUID     string          // VF port GUID set during ADD; used by CHECK
+	HostIFIPoIBMode     string          // VF netdevice IPoIB mode before ipoibMode was applied
+	HostIFMTU           int             // VF netdevice MTU before ipoibMode or mtu were applied
+	HostNodeDesc        string          // VF RDMA device node description before nodeDescription was applied
+	ContIFNames         string          // VF names after in the container; used during deletion
+	ContParentIFName    string          // VF name in the container when it is the parent of an IPoIB child; used during deletion
+	ContainerID         string          // Container ID of the ADD invocation; used during garbage collection
+	IfName              string          // Pod interface name of the ADD invocation; used during garbage collection
+	StdinData           []byte          // Network configuration of the ADD invocation; used to release IPAM during garbage collection
+	NetnsPath           string          // Pod network namespace path; used during garbage collection
+	DevInfoPath         string          // Device-info file path; used during garbage collection
+	CDISpecPath         string          // CDI spec file path; used during garbage collection
+	ConfigHash          string          // Hash of the ADD invocation; used to detect repeated ADD
+	Result              *current.Result // Result of the ADD invocation; returned on repeated ADD
+	GUID                string          `json:"-"` // Taken from either CNI_ARGS "guid" attribute or from RuntimeConfig
+	PKey                string          `json:"pkey"`
+	LinkState           string          `json:"link_state,omitempty"` // auto|enable|disable
+	RdmaIsolation       bool            `json:"rdmaIsolation,omitempty"`
+	IPoIBChildPKey      bool            `json:"ipoibChildPKey,omitempty"`
+	PFChildMode         bool            `json:"pfChildMode,omitempty"`
+	IPoIBMode           string          `json:"ipoibMode,omitempty"`
+	MTU                 int             `json:"mtu,omitempty"`
+	WaitForPortActive   int             `json:"waitForPortActive,omitempty"`
+	WaitForPhysLinkUp   bool            `json:"waitForPhysLinkUp,omitempty"`
+	CDISpec             bool            `json:"cdiSpec,omitempty"`
+	GUIDPool            []string        `json:"guidPool,omitempty"`
+	GUIDMode            string          `json:"guidMode,omitempty"`
+	GUIDPrefix          string          `json:"guidPrefix,omitempty"`
+	NodeGUID            string          `json:"nodeGUID,omitempty"`
+	PortGUID            string          `json:"portGUID,omitempty"`
+	NodeDescription     string          `json:"nodeDescription,omitempty"` // template of the VF RDMA device node description
+	IBKubernetesEnabled bool            `json:"ibKubernetesEnabled,omitempty"`
+	VfioPciMode         bool            `json:"vfioPciMode,omitempty"` // Skip SR-IOV network setup, default false
+	IsVFDevice          bool            `json:"-"`                     // Runtime flag: true if device is VF, false if PF
+	LogLevel            string          `json:"logLevel,omitempty"`    // debug|info|warning|error, default info
+	LogFile             string          `json:"logFile,omitempty"`     // log file path, logs are written to stderr if not set
+	LockScope           string          `json:"lockScope,omitempty"`   // global|pf|vf, default global
+	LockTimeout         int             `json:"lockTimeout,omitempty"` // seconds to wait for the CNI lock
 	RdmaNetState        rdmatypes.RdmaNetState
 	RuntimeConfig       RuntimeConf `json:"runtimeConfig,omitempty"`
 	Args                struct {
@@ -75,6 +109,38 @@ func (n NetConf) MarshalJSON() ([]byte, error) {
 // RuntimeConf represents the plugin's runtime configurations
 type RuntimeConf struct {
 	InfinibandGUID string `json:"infinibandGUID"`
+	NodeGUID       string `json:"nodeGUID,omitempty"`
+	PortGUID       string `json:"portGUID,omitempty"`
+}
+
+// GetNodeGUID returns the node GUID requested for the VF, taken from the nodeGUID runtime config, the nodeGUID
+// config or GUID, which sets both the node and the port GUID
+func (n *NetConf) GetNodeGUID() string {
+	if n.RuntimeConfig.NodeGUID != "" {
+		return n.RuntimeConfig.NodeGUID
+	}
+	if n.NodeGUID != "" {
+		return n.NodeGUID
+	}
+	return n.GUID
+}
+
+// IsNodeGUIDShared returns true if the node GUID is set by the nodeGUID config, which may be shared by several VFs,
+// rather than per attachment by the nodeGUID runtime config or GUID
+func (n *NetConf) IsNodeGUIDShared() bool {
+	return n.RuntimeConfig.NodeGUID == "" && n.NodeGUID != ""
+}
+
+// GetPortGUID returns the port GUID requested for the VF, taken from the portGUID runtime config, the portGUID
+// config or GUID, which sets both the node and the port GUID
+func (n *NetConf) GetPortGUID() string {
+	if n.RuntimeConfig.PortGUID != "" {
+		return n.RuntimeConfig.PortGUID
+	}
+	if n.PortGUID != "" {
+		return n.PortGUID
+	}
+	return n.GUID
 }
 
 // Manager provides interface invoke sriov nic related operations
@@ -83,6 +149,8 @@ type Manager interface {
 	ReleaseVF(conf *NetConf, podifName string, cid string, netns ns.NetNS) error
 	ResetVFConfig(conf *NetConf) error
 	ApplyVFConfig(conf *NetConf) error
+	CheckVFConfig(conf *NetConf, podifName string, netns ns.NetNS) error
+	RestoreVFName(conf *NetConf) error
 }
 
 // mocked netlink interface
@@ -91,14 +159,26 @@ type Manager interface {
 // NetlinkManager is an interface to mock nelink library
 type NetlinkManager interface {
 	LinkByName(string) (netlink.Link, error)
+	LinkByIndex(int) (netlink.Link, error)
+	LinkAdd(netlink.Link) error
+	LinkDel(netlink.Link) error
 	LinkSetUp(netlink.Link) error
 	LinkSetDown(netlink.Link) error
 	LinkSetNsFd(netlink.Link, int) error
 	LinkSetName(netlink.Link, string) error
+	LinkSetMTU(netlink.Link, int) error
 	LinkSetVfState(netlink.Link, int, uint32) error
 	LinkSetVfPortGUID(netlink.Link, int, net.HardwareAddr) error
 	LinkSetVfNodeGUID(netlink.Link, int, net.HardwareAddr) error
 	LinkDelAltName(netlink.Link, string) error
+	LinkVfGUIDs(netlink.Link) ([]VfGUIDs, error)
+}
+
+// VfGUIDs holds the node and port GUIDs of a VF as reported by its PF
+type VfGUIDs struct {
+	VF       int
+	NodeGUID string
+	PortGUID string
 }
 
 // PciUtils is interface to help in SR-IOV functions
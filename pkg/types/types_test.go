@@ -228,4 +228,29 @@ var _ = Describe("Types", func() {
 				"JSON output should be much longer than the broken version, got: %s", jsonStr)
 		})
 	})
+	Context("Requested VF GUIDs", func() {
+		It("Should use GUID for both the node and the port GUID", func() {
+			netConf := &NetConf{IbSriovNetConf: IbSriovNetConf{GUID: "02:00:00:00:00:00:00:01"}}
+			Expect(netConf.GetNodeGUID()).To(Equal("02:00:00:00:00:00:00:01"))
+			Expect(netConf.GetPortGUID()).To(Equal("02:00:00:00:00:00:00:01"))
+			Expect(netConf.IsNodeGUIDShared()).To(BeFalse())
+		})
+		It("Should prefer the runtime config over the config and GUID", func() {
+			netConf := &NetConf{IbSriovNetConf: IbSriovNetConf{
+				GUID:     "02:00:00:00:00:00:00:01",
+				NodeGUID: "02:00:00:00:00:00:00:02",
+				PortGUID: "02:00:00:00:00:00:00:03",
+			}}
+			netConf.RuntimeConfig.PortGUID = "02:00:00:00:00:00:00:04"
+			Expect(netConf.GetNodeGUID()).To(Equal("02:00:00:00:00:00:00:02"))
+			Expect(netConf.GetPortGUID()).To(Equal("02:00:00:00:00:00:00:04"))
+			Expect(netConf.IsNodeGUIDShared()).To(BeTrue())
+		})
+		It("Should not share a node GUID given by the runtime config", func() {
+			netConf := &NetConf{IbSriovNetConf: IbSriovNetConf{NodeGUID: "02:00:00:00:00:00:00:02"}}
+			netConf.RuntimeConfig.NodeGUID = "02:00:00:00:00:00:00:05"
+			Expect(netConf.GetNodeGUID()).To(Equal("02:00:00:00:00:00:00:05"))
+			Expect(netConf.IsNodeGUIDShared()).To(BeFalse())
+		})
+	})
 })
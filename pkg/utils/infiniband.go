@@ -0,0 +1,487 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ArpHrdInfiniband is the ARP hardware type of InfiniBand netdevices as reported in sysfs
+	ArpHrdInfiniband = "32"
+	// IBPortStateActive is the state of an InfiniBand port which is ready for traffic
+	IBPortStateActive = "ACTIVE"
+	// IBPortPhysStateLinkUp is the physical state of an InfiniBand port whose physical link is up
+	IBPortPhysStateLinkUp = "LinkUp"
+	// PKeyFullMembership is the pkey bit marking full membership of the partition
+	PKeyFullMembership uint16 = 0x8000
+	// pkeyBaseMask masks the partition number of a pkey
+	pkeyBaseMask uint16 = 0x7fff
+
+	pollInterval = 100 * time.Millisecond
+	// RdmaCharDevDir is the directory of the RDMA char devices
+	RdmaCharDevDir = "/dev/infiniband"
+	// RdmaCmCharDev is the char device of the RDMA connection manager, shared by all RDMA devices
+	RdmaCmCharDev = RdmaCharDevDir + "/rdma_cm"
+
+	// IPoIBModeDatagram is the IPoIB datagram (UD) mode
+	IPoIBModeDatagram = "datagram"
+	// IPoIBModeConnected is the IPoIB connected (RC) mode
+	IPoIBModeConnected = "connected"
+	// IPoIBMinMTU is the minimal MTU of an IPoIB netdevice
+	IPoIBMinMTU = 68
+	// IPoIBDatagramMaxMTU is the maximal MTU in datagram mode, the 4K IB MTU minus the IPoIB header
+	IPoIBDatagramMaxMTU = 4092
+	// IPoIBConnectedMaxMTU is the maximal MTU in connected mode
+	IPoIBConnectedMaxMTU = 65520
+	// NodeDescMaxLen is the maximal length of an InfiniBand node description
+	NodeDescMaxLen = 64
+)
+
+// IsInfinibandNetdev checks if the netdevice link type is InfiniBand
+func IsInfinibandNetdev(ifName string) bool {
+	data, err := os.ReadFile(filepath.Join(NetDirectory, ifName, "type")) /* #nosec G304 */
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(data)) == ArpHrdInfiniband
+}
+
+// GetSriovIBPfs returns the InfiniBand PF netdevices which have SR-IOV VFs configured
+func GetSriovIBPfs() ([]string, error) {
+	entries, err := os.ReadDir(NetDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", NetDirectory, err)
+	}
+
+	var pfs []string
+	for _, entry := range entries {
+		ifName := entry.Name()
+		if !IsInfinibandNetdev(ifName) {
+			continue
+		}
+		numVfs, err := GetSriovNumVfs(ifName)
+		if err != nil || numVfs == 0 {
+			continue
+		}
+		pfs = append(pfs, ifName)
+	}
+	return pfs, nil
+}
+
+// GetNetdevRdmaDevs returns the RDMA devices of the PCI device backing the netdevice
+func GetNetdevRdmaDevs(ifName string) ([]string, error) {
+	rdmaDir := filepath.Join(NetDirectory, ifName, "device", "infiniband")
+	entries, err := os.ReadDir(rdmaDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RDMA devices of %s: %v", ifName, err)
+	}
+
+	rdmaDevs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		rdmaDevs = append(rdmaDevs, entry.Name())
+	}
+	return rdmaDevs, nil
+}
+
+// GetPciRdmaDevs returns the RDMA devices of the PCI device which are present in the current namespace
+func GetPciRdmaDevs(pciAddr string) ([]string, error) {
+	rdmaDir := filepath.Join(SysBusPci, pciAddr, "infiniband")
+	entries, err := os.ReadDir(rdmaDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RDMA devices of %s: %v", pciAddr, err)
+	}
+
+	rdmaDevs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		rdmaDevs = append(rdmaDevs, entry.Name())
+	}
+	return rdmaDevs, nil
+}
+
+// WaitForVFNetdev waits for the netdevice of the VF to be present in the current namespace and returns its name
+func WaitForVFNetdev(pciAddr string, timeout time.Duration) (string, error) {
+	var ifName string
+	found := pollUntil(timeout, func() bool {
+		name, err := GetVFLinkNames(pciAddr)
+		ifName = name
+		return err == nil
+	})
+	if !found {
+		return "", fmt.Errorf("timed out after %s waiting for netdevice of VF %s", timeout, pciAddr)
+	}
+	return ifName, nil
+}
+
+// WaitForPciRdmaDev waits for an RDMA device of the PCI device to be present in the current namespace
+// and returns its name
+func WaitForPciRdmaDev(pciAddr string, timeout time.Duration) (string, error) {
+	var rdmaDev string
+	found := pollUntil(timeout, func() bool {
+		rdmaDevs, err := GetPciRdmaDevs(pciAddr)
+		if err != nil || len(rdmaDevs) == 0 {
+			return false
+		}
+		rdmaDev = rdmaDevs[0]
+		return true
+	})
+	if !found {
+		return "", fmt.Errorf("timed out after %s waiting for RDMA device of %s", timeout, pciAddr)
+	}
+	return rdmaDev, nil
+}
+
+// pollUntil calls condition every pollInterval until it returns true or timeout expires
+func pollUntil(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if condition() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// GetRdmaDevPortStates returns the state (e.g. ACTIVE, DOWN, INIT) of each port of an RDMA device
+func GetRdmaDevPortStates(rdmaDev string) (map[int]string, error) {
+	portsDir := filepath.Join(InfinibandDirectory, rdmaDev, "ports")
+	entries, err := os.ReadDir(portsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ports of RDMA device %s: %v", rdmaDev, err)
+	}
+
+	states := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		port, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		state, err := readIBPortAttr(rdmaDev, port, "state")
+		if err != nil {
+			return nil, err
+		}
+		states[port] = state
+	}
+	return states, nil
+}
+
+// HasActiveIBPort checks if a port of the RDMA devices of one of the netdevices is ACTIVE. If none is, it returns
+// the state of the ports found, or why their state could not be read.
+func HasActiveIBPort(ifNames []string) (bool, []string) {
+	var inactive []string
+	for _, ifName := range ifNames {
+		rdmaDevs, err := GetNetdevRdmaDevs(ifName)
+		if err != nil {
+			inactive = append(inactive, err.Error())
+			continue
+		}
+		for _, rdmaDev := range rdmaDevs {
+			states, err := GetRdmaDevPortStates(rdmaDev)
+			if err != nil {
+				inactive = append(inactive, err.Error())
+				continue
+			}
+			for port, state := range states {
+				if state == IBPortStateActive {
+					return true, nil
+				}
+				inactive = append(inactive, fmt.Sprintf("%s (%s) port %d is %s", ifName, rdmaDev, port, state))
+			}
+		}
+	}
+	return false, inactive
+}
+
+// WaitForRdmaDevPortsActive waits for all ports of an RDMA device to be ACTIVE and, if checkPhysState is set,
+// their physical state to be LinkUp. On timeout the error includes the last seen state of the ports.
+func WaitForRdmaDevPortsActive(rdmaDev string, checkPhysState bool, timeout time.Duration) error {
+	var lastState string
+	active := pollUntil(timeout, func() bool {
+		states, err := getRdmaDevPortsState(rdmaDev, checkPhysState)
+		if err != nil {
+			lastState = err.Error()
+			return false
+		}
+		lastState = strings.Join(states, ", ")
+		return len(states) == 0
+	})
+	if !active {
+		return fmt.Errorf("timed out after %s waiting for ports of RDMA device %s to be active, last state: %s",
+			timeout, rdmaDev, lastState)
+	}
+	return nil
+}
+
+// getRdmaDevPortsState returns the state of the ports of an RDMA device which are not yet active
+func getRdmaDevPortsState(rdmaDev string, checkPhysState bool) ([]string, error) {
+	states, err := GetRdmaDevPortStates(rdmaDev)
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		return nil, fmt.Errorf("RDMA device %s has no ports", rdmaDev)
+	}
+
+	var inactive []string
+	for port, state := range states {
+		physState := ""
+		if checkPhysState {
+			if physState, err = readIBPortAttr(rdmaDev, port, "phys_state"); err != nil {
+				return nil, err
+			}
+		}
+		if state == IBPortStateActive && (!checkPhysState || physState == IBPortPhysStateLinkUp) {
+			continue
+		}
+		if checkPhysState {
+			inactive = append(inactive, fmt.Sprintf("port %d state %s phys_state %s", port, state, physState))
+		} else {
+			inactive = append(inactive, fmt.Sprintf("port %d state %s", port, state))
+		}
+	}
+	sort.Strings(inactive)
+	return inactive, nil
+}
+
+// readIBPortAttr reads a port attribute formatted as "<value>: <name>", e.g "4: ACTIVE", and returns its name
+func readIBPortAttr(rdmaDev string, port int, attr string) (string, error) {
+	attrFile := filepath.Join(InfinibandDirectory, rdmaDev, "ports", strconv.Itoa(port), attr)
+	data, err := os.ReadFile(attrFile) /* #nosec G304 */
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s of RDMA device %s port %d: %v", attr, rdmaDev, port, err)
+	}
+
+	value := strings.TrimSpace(string(data))
+	if _, name, found := strings.Cut(value, ":"); found {
+		return strings.TrimSpace(name), nil
+	}
+	return value, nil
+}
+
+// ParsePKey parses a 16-bit hex pkey, e.g. "0x8005". The partition number (lower 15 bits) must not be 0.
+func ParsePKey(pkey string) (uint16, error) {
+	value := strings.TrimPrefix(strings.ToLower(pkey), "0x")
+	parsed, err := strconv.ParseUint(value, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("pkey %s is not a 16-bit hex value", pkey)
+	}
+
+	key := uint16(parsed)
+	if key&pkeyBaseMask == 0 {
+		return 0, fmt.Errorf("pkey %s is not a valid partition", pkey)
+	}
+	return key, nil
+}
+
+// PKeyInTable checks if the pkey partition is in the pkey table. If the pkey has the full membership bit set,
+// the table entry must have it set as well.
+func PKeyInTable(pkey uint16, table []uint16) bool {
+	for _, entry := range table {
+		if entry&pkeyBaseMask != pkey&pkeyBaseMask {
+			continue
+		}
+		if pkey&PKeyFullMembership == 0 || entry&PKeyFullMembership != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRdmaDevPKeys returns the valid entries of the pkey tables of all the ports of an RDMA device
+func GetRdmaDevPKeys(rdmaDev string) ([]uint16, error) {
+	pkeyFiles, err := filepath.Glob(filepath.Join(InfinibandDirectory, rdmaDev, "ports", "*", "pkeys", "*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pkeys of RDMA device %s: %v", rdmaDev, err)
+	}
+
+	pkeys := make([]uint16, 0, len(pkeyFiles))
+	for _, pkeyFile := range pkeyFiles {
+		data, err := os.ReadFile(pkeyFile) /* #nosec G304 */
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pkey %s of RDMA device %s: %v", pkeyFile, rdmaDev, err)
+		}
+		pkey, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"), 16, 16)
+		// empty table entries are 0x0000
+		if err != nil || uint16(pkey)&pkeyBaseMask == 0 {
+			continue
+		}
+		pkeys = append(pkeys, uint16(pkey))
+	}
+	return pkeys, nil
+}
+
+// WaitForRdmaDevPKey waits for the pkey partition to be in the pkey table of an RDMA device, the subnet manager
+// programs the table once the port is ACTIVE. On timeout the error includes the last read error, if any.
+func WaitForRdmaDevPKey(rdmaDev string, pkey uint16, timeout time.Duration) error {
+	var lastErr error
+	found := pollUntil(timeout, func() bool {
+		var table []uint16
+		table, lastErr = GetRdmaDevPKeys(rdmaDev)
+		return lastErr == nil && PKeyInTable(pkey, table)
+	})
+	if !found {
+		if lastErr != nil {
+			return fmt.Errorf("timed out after %s waiting for pkey 0x%04x in the pkey table of RDMA device %s: %v",
+				timeout, pkey, rdmaDev, lastErr)
+		}
+		return fmt.Errorf("timed out after %s waiting for pkey 0x%04x in the pkey table of RDMA device %s",
+			timeout, pkey, rdmaDev)
+	}
+	return nil
+}
+
+// ValidateIPoIBMTU checks that the MTU is allowed by the IPoIB mode
+func ValidateIPoIBMTU(mode string, mtu int) error {
+	maxMTU := IPoIBConnectedMaxMTU
+	if mode == IPoIBModeDatagram {
+		maxMTU = IPoIBDatagramMaxMTU
+	}
+	if mtu < IPoIBMinMTU || mtu > maxMTU {
+		return fmt.Errorf("mtu %d is out of range [%d, %d] of IPoIB %s mode", mtu, IPoIBMinMTU, maxMTU, mode)
+	}
+	return nil
+}
+
+// GetIPoIBMode returns the IPoIB mode of a netdevice
+func GetIPoIBMode(ifName string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(NetDirectory, ifName, "mode")) /* #nosec G304 */
+	if err != nil {
+		return "", fmt.Errorf("failed to read IPoIB mode of %s: %v", ifName, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SetIPoIBMode sets the IPoIB mode of a netdevice, the kernel adjusts the MTU to the new mode
+func SetIPoIBMode(ifName, mode string) error {
+	// the kernel expects the value to be newline terminated
+	err := os.WriteFile(filepath.Join(NetDirectory, ifName, "mode"), []byte(mode+"\n"), OwnerReadWriteOthersReadAttrs)
+	if err != nil {
+		return fmt.Errorf("failed to set IPoIB mode of %s to %s: %v", ifName, mode, err)
+	}
+	return nil
+}
+
+// getPciRdmaDev returns the single RDMA device of a PCI device in the current namespace
+func getPciRdmaDev(pciAddr string) (string, error) {
+	rdmaDevs, err := GetPciRdmaDevs(pciAddr)
+	if err != nil {
+		return "", err
+	}
+	if len(rdmaDevs) != 1 {
+		return "", fmt.Errorf("expected one RDMA device for PCI device %s, found %v", pciAddr, rdmaDevs)
+	}
+	return rdmaDevs[0], nil
+}
+
+// RdmaDevInfo holds the identity of an RDMA device and of its first port
+type RdmaDevInfo struct {
+	Name     string
+	Uverbs   string // uverbs char device, e.g. /dev/infiniband/uverbs2
+	Umad     string // umad char device, empty if the ib_umad module is not loaded
+	PortGUID string
+	LID      string
+}
+
+// GetPciRdmaDevInfo returns the RDMA device of a PCI device along with its uverbs and umad char devices and
+// the GUID and LID of its first port
+func GetPciRdmaDevInfo(pciAddr string) (*RdmaDevInfo, error) {
+	rdmaDev, err := getPciRdmaDev(pciAddr)
+	if err != nil {
+		return nil, err
+	}
+	info := &RdmaDevInfo{Name: rdmaDev}
+
+	entries, err := os.ReadDir(filepath.Join(SysBusPci, pciAddr, "infiniband_verbs"))
+	if err != nil || len(entries) == 0 {
+		return nil, fmt.Errorf("failed to find uverbs device of %s: %v", pciAddr, err)
+	}
+	info.Uverbs = filepath.Join(RdmaCharDevDir, entries[0].Name())
+
+	// infiniband_mad holds the umad and issm devices of the port
+	entries, _ = os.ReadDir(filepath.Join(SysBusPci, pciAddr, "infiniband_mad"))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "umad") {
+			info.Umad = filepath.Join(RdmaCharDevDir, entry.Name())
+			break
+		}
+	}
+
+	portDir := filepath.Join(InfinibandDirectory, rdmaDev, "ports", "1")
+	gid, err := os.ReadFile(filepath.Join(portDir, "gids", "0")) /* #nosec G304 */
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GID of RDMA device %s: %v", rdmaDev, err)
+	}
+	// the port GUID is the interface ID, the lower 64 bits of the link local GID
+	groups := strings.Split(strings.TrimSpace(string(gid)), ":")
+	if len(groups) != 8 {
+		return nil, fmt.Errorf("invalid GID %q of RDMA device %s", strings.TrimSpace(string(gid)), rdmaDev)
+	}
+	guid := make([]string, 0, 8)
+	for _, group := range groups[4:] {
+		if len(group) != 4 {
+			return nil, fmt.Errorf("invalid GID %q of RDMA device %s", strings.TrimSpace(string(gid)), rdmaDev)
+		}
+		guid = append(guid, group[:2], group[2:])
+	}
+	info.PortGUID = strings.Join(guid, ":")
+
+	lid, err := os.ReadFile(filepath.Join(portDir, "lid")) /* #nosec G304 */
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LID of RDMA device %s: %v", rdmaDev, err)
+	}
+	info.LID = strings.TrimSpace(string(lid))
+	return info, nil
+}
+
+// GetPciRdmaDevNodeDesc returns the node description of the RDMA device of a PCI device
+func GetPciRdmaDevNodeDesc(pciAddr string) (string, error) {
+	rdmaDev, err := getPciRdmaDev(pciAddr)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(InfinibandDirectory, rdmaDev, "node_desc")) /* #nosec G304 */
+	if err != nil {
+		return "", fmt.Errorf("failed to read node description of RDMA device %s: %v", rdmaDev, err)
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+// GetPciRdmaDevNodeGUID returns the node GUID of the RDMA device of a PCI device
+func GetPciRdmaDevNodeGUID(pciAddr string) (string, error) {
+	rdmaDev, err := getPciRdmaDev(pciAddr)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(InfinibandDirectory, rdmaDev, "node_guid")) /* #nosec G304 */
+	if err != nil {
+		return "", fmt.Errorf("failed to read node GUID of RDMA device %s: %v", rdmaDev, err)
+	}
+	// node_guid is formatted as four groups of two bytes, e.g. 0002:c903:0000:0001
+	guid, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(string(data)), ":", ""))
+	if err != nil || len(guid) != 8 {
+		return "", fmt.Errorf("invalid node GUID %q of RDMA device %s", strings.TrimSpace(string(data)), rdmaDev)
+	}
+	return net.HardwareAddr(guid).String(), nil
+}
+
+// SetPciRdmaDevNodeDesc sets the node description of the RDMA device of a PCI device
+func SetPciRdmaDevNodeDesc(pciAddr, desc string) error {
+	rdmaDev, err := getPciRdmaDev(pciAddr)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(InfinibandDirectory, rdmaDev, "node_desc"), []byte(desc), OwnerReadWriteOthersReadAttrs)
+	if err != nil {
+		return fmt.Errorf("failed to set node description of RDMA device %s: %v", rdmaDev, err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+const lockRetryDelay = 100 * time.Millisecond
+
+// ErrLockTimeout is returned by LockFile if the lock is not acquired within the timeout
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// LockFile takes the file lock, creating its directory if needed. It fails with ErrLockTimeout if the lock is
+// not acquired within timeout.
+func LockFile(lockFile string, timeout time.Duration) (*flock.Flock, error) {
+	err := os.MkdirAll(filepath.Dir(lockFile), OwnerReadWriteExecuteAttrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file directory %q: %v", filepath.Dir(lockFile), err)
+	}
+
+	lock := flock.New(lockFile)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	locked, err := lock.TryLockContext(ctx, lockRetryDelay)
+	if !locked {
+		if err == nil || errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w %s after %s", ErrLockTimeout, lockFile, timeout)
+		}
+		return nil, fmt.Errorf("failed to acquire lock %s: %v", lockFile, err)
+	}
+	return lock, nil
+}
@@ -1,10 +1,14 @@
 package utils
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"slices"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/k8snetworkplumbingwg/rdma-cni/pkg/rdma"
+	"github.com/vishvananda/netlink"
 )
 
 var rdmaManager = rdma.NewRdmaManager()
@@ -31,8 +35,8 @@ func MoveRdmaDevToNs(rdmaDev string, targetNs ns.NetNS) error {
 	return nil
 }
 
-// Move RDMA device to namespace
-func MoveRdmaDevToNsPci(pciDev string, targetNs ns.NetNS) (string, error) { // (hostRdmaDev, error)
+// GetRdmaDevForPciDev returns the RDMA device of the PCI device, expecting exactly one
+func GetRdmaDevForPciDev(pciDev string) (string, error) {
 	rdmaDevs := rdmaManager.GetRdmaDevsForPciDev(pciDev)
 	if len(rdmaDevs) == 0 {
 		return "", fmt.Errorf("failed to get RDMA devices for PCI device: %s. No RDMA devices found", pciDev)
@@ -43,15 +47,7 @@ func MoveRdmaDevToNsPci(pciDev string, targetNs ns.NetNS) (string, error) { // (
 		return "", fmt.Errorf(
 			"discovered more than one RDMA device %v for PCI device %s. Unsupported state", rdmaDevs, pciDev)
 	}
-
-	// Move RDMA device to container namespace
-	rdmaDev := rdmaDevs[0]
-
-	err := MoveRdmaDevToNs(rdmaDev, targetNs)
-	if err != nil {
-		return "", fmt.Errorf("failed to move RDMA device %s to namespace. %v", rdmaDev, err)
-	}
-	return rdmaDev, nil
+	return rdmaDevs[0], nil
 }
 
 // Move RDMA device from namespace to current (default) namespace
@@ -71,3 +67,49 @@ func MoveRdmaDevFromNs(rdmaDev string, sourceNs ns.NetNS) error {
 	}
 	return err
 }
+
+// Check that RDMA device exists in namespace
+func CheckRdmaDevInNs(rdmaDev string, netNs ns.NetNS) error {
+	err := netNs.Do(func(_ ns.NetNS) error {
+		_, err := netlink.RdmaLinkByName(rdmaDev)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to find RDMA device %s in namespace %s. %v", rdmaDev, netNs.Path(), err)
+	}
+	return nil
+}
+
+// GetRdmaDevInNsByGUID returns the name of the RDMA device with the given node GUID in namespace,
+// or an empty string if no such device exists
+func GetRdmaDevInNsByGUID(guid string, netNs ns.NetNS) (string, error) {
+	nodeGUID, err := net.ParseMAC(guid)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse guid %s: %v", guid, err)
+	}
+
+	var rdmaDev string
+	err = netNs.Do(func(_ ns.NetNS) error {
+		links, err := netlink.RdmaLinkList()
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			linkGUID, err := net.ParseMAC(link.Attrs.NodeGuid)
+			if err != nil {
+				continue
+			}
+			// netlink reports the node GUID in reverse byte order
+			slices.Reverse(linkGUID)
+			if bytes.Equal(linkGUID, nodeGUID) {
+				rdmaDev = link.Attrs.Name
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list RDMA devices in namespace %s. %v", netNs.Path(), err)
+	}
+	return rdmaDev, nil
+}
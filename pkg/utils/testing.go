@@ -36,6 +36,7 @@ type tmpSysFs struct {
 var ts = tmpSysFs{
 	dirList: []string{
 		"sys/class/net",
+		"sys/class/infiniband",
 		"sys/bus/pci/devices",
 		"sys/bus/pci/drivers/mlx5_core",
 		"sys/bus/pci/drivers/vfio-pci",
@@ -44,10 +45,39 @@ var ts = tmpSysFs{
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/net/ib2",
 		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/ib3",
 		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/ib4",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/infiniband/mlx5_0/ports/1",
+		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/infiniband/mlx5_3/ports/1",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/pkeys",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/gids",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband_verbs/uverbs2",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband_mad/issm2",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband_mad/umad2",
 	},
 	fileList: map[string][]byte{
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/sriov_numvfs": []byte("2"),
 		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/sriov_numvfs": []byte("0"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/net/ib0/type": []byte("32"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/net/ib1/type": []byte("32"),
+		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/ib3/type": []byte("32"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/net/ib0/mode": []byte("datagram\n"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/net/ib1/mode": []byte("datagram\n"),
+
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/infiniband/mlx5_0/ports/1/state":   []byte("4: ACTIVE"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/state":   []byte("1: DOWN"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/node_desc":       []byte("MT4120 ConnectX-5 Mellanox Technologies\n"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/pkeys/0": []byte("0xffff"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/pkeys/1": []byte("0x8005"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/pkeys/2": []byte("0x0006"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/pkeys/3": []byte("0x0000"),
+
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/infiniband/mlx5_0/ports/1/phys_state": []byte("5: LinkUp"),
+		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/infiniband/mlx5_3/ports/1/state":      []byte("4: ACTIVE"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/phys_state": []byte("2: Polling"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/lid":        []byte("0x0005"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/ports/1/gids/0": []byte(
+			"fe80:0000:0000:0000:0002:c903:0000:0001"),
+
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2/node_guid": []byte("0002:c903:0000:0001\n"),
 	},
 	netSymlinks: map[string]string{
 		"sys/class/net/ib0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/net/ib0",
@@ -67,6 +97,10 @@ var ts = tmpSysFs{
 		"sys/bus/pci/devices/0000:af:06.0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0",
 		"sys/bus/pci/devices/0000:af:06.1": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1",
 		"sys/bus/pci/devices/0000:05:00.0": "sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0",
+
+		"sys/class/infiniband/mlx5_0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/infiniband/mlx5_0",
+		"sys/class/infiniband/mlx5_2": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2",
+		"sys/class/infiniband/mlx5_3": "sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/infiniband/mlx5_3",
 	},
 	vfSymlinks: map[string]string{
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/virtfn0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0",
@@ -134,6 +168,7 @@ func CreateTmpSysFs() error {
 
 	SysBusPci = filepath.Join(ts.dirRoot, SysBusPci)
 	NetDirectory = filepath.Join(ts.dirRoot, NetDirectory)
+	InfinibandDirectory = filepath.Join(ts.dirRoot, InfinibandDirectory)
 	return nil
 }
 
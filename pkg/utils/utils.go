@@ -17,6 +17,8 @@ var (
 	NetDirectory = "/sys/class/net"
 	// SysBusPci is sysfs pci device directory
 	SysBusPci = "/sys/bus/pci/devices"
+	// InfinibandDirectory sysfs infiniband directory
+	InfinibandDirectory = "/sys/class/infiniband"
 )
 
 const (
@@ -121,6 +123,16 @@ func GetPciAddress(ifName string, vf int) (string, error) {
 	return pciaddr, nil
 }
 
+// GetNetdevPciAddress returns the PCI address of the device of a network interface
+func GetNetdevPciAddress(ifName string) (string, error) {
+	devLink := filepath.Join(NetDirectory, ifName, "device")
+	pciinfo, err := os.Readlink(devLink)
+	if err != nil {
+		return "", fmt.Errorf("can't read the device symbolic link of the device %q: %v", ifName, err)
+	}
+	return filepath.Base(pciinfo), nil
+}
+
 // GetVFLinkNames returns VF's network interface name given it's PCI addr
 func GetVFLinkNames(pciAddr string) (string, error) {
 	vfDir := filepath.Join(SysBusPci, pciAddr, "net")
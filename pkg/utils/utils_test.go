@@ -1,7 +1,11 @@
 package utils
 
 import (
+	"errors"
 	"net"
+	"os"
+	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -58,6 +62,15 @@ var _ = Describe("Utils", func() {
 			Expect(err).To(HaveOccurred(), "Not existing VF id should return an error")
 		})
 	})
+	Context("Checking GetNetdevPciAddress function", func() {
+		It("Assuming existing interface", func() {
+			Expect(GetNetdevPciAddress("ib0")).To(Equal("0000:af:00.1"))
+		})
+		It("Assuming not existing interface", func() {
+			_, err := GetNetdevPciAddress("enp175s0f2")
+			Expect(err).To(HaveOccurred())
+		})
+	})
 	Context("Checking GetVFLinkNames function", func() {
 		It("Assuming existing vf", func() {
 			result, err := GetVFLinkNamesFromVFID("ib0", 0)
@@ -133,4 +146,214 @@ var _ = Describe("Utils", func() {
 			Expect(result).To(Equal(false), "Device not bound to driver should return false")
 		})
 	})
+	Context("Checking pkey functions", func() {
+		It("Assuming valid pkeys", func() {
+			for pkey, expected := range map[string]uint16{"0x8005": 0x8005, "0x7FFF": 0x7fff, "5": 0x5, "0xffff": 0xffff} {
+				parsed, err := ParsePKey(pkey)
+				Expect(err).NotTo(HaveOccurred(), pkey)
+				Expect(parsed).To(Equal(expected), pkey)
+			}
+		})
+		It("Assuming invalid pkeys", func() {
+			for _, pkey := range []string{"", "0x", "0x10000", "pkey", "0x0000", "0x8000"} {
+				_, err := ParsePKey(pkey)
+				Expect(err).To(HaveOccurred(), pkey)
+			}
+		})
+		It("Assuming pkey table lookups", func() {
+			table := []uint16{0xffff, 0x8005, 0x0006}
+			Expect(PKeyInTable(0x8005, table)).To(BeTrue())
+			Expect(PKeyInTable(0x0005, table)).To(BeTrue(), "Limited membership request should match full member")
+			Expect(PKeyInTable(0x0006, table)).To(BeTrue())
+			Expect(PKeyInTable(0x8006, table)).To(BeFalse(), "Full membership request should not match limited member")
+			Expect(PKeyInTable(0x8007, table)).To(BeFalse())
+		})
+	})
+	Context("Checking InfiniBand sysfs functions", func() {
+		It("Assuming InfiniBand netdevice", func() {
+			Expect(IsInfinibandNetdev("ib0")).To(BeTrue())
+			Expect(IsInfinibandNetdev("ib2")).To(BeFalse(), "Netdevice without type should not be InfiniBand")
+		})
+		It("Assuming SR-IOV enabled InfiniBand PFs", func() {
+			pfs, err := GetSriovIBPfs()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pfs).To(Equal([]string{"ib0"}), "Only PFs with configured VFs should be returned")
+		})
+		It("Assuming netdevice with RDMA device", func() {
+			rdmaDevs, err := GetNetdevRdmaDevs("ib0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rdmaDevs).To(Equal([]string{"mlx5_0"}))
+		})
+		It("Assuming netdevice without RDMA device", func() {
+			_, err := GetNetdevRdmaDevs("ib2")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming RDMA device port states", func() {
+			states, err := GetRdmaDevPortStates("mlx5_0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(states).To(Equal(map[int]string{1: IBPortStateActive}))
+
+			states, err = GetRdmaDevPortStates("mlx5_2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(states).To(Equal(map[int]string{1: "DOWN"}))
+		})
+		It("Assuming ACTIVE port on SR-IOV enabled PFs", func() {
+			active, _ := HasActiveIBPort([]string{"ib0"})
+			Expect(active).To(BeTrue())
+		})
+		It("Assuming ACTIVE port on an InfiniBand netdevice without VFs, as used by pfChildMode", func() {
+			Expect(IsInfinibandNetdev("ib3")).To(BeTrue())
+			active, _ := HasActiveIBPort([]string{"ib3"})
+			Expect(active).To(BeTrue())
+		})
+		It("Assuming no ACTIVE port", func() {
+			active, inactive := HasActiveIBPort([]string{"ib1", "ib2"})
+			Expect(active).To(BeFalse())
+			Expect(inactive).To(HaveLen(2))
+			Expect(inactive[0]).To(Equal("ib1 (mlx5_2) port 1 is DOWN"))
+		})
+		It("Assuming not existing RDMA device", func() {
+			_, err := GetRdmaDevPortStates("mlx5_9")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming PCI device with RDMA device", func() {
+			info, err := GetPciRdmaDevInfo("0000:af:06.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info).To(Equal(&RdmaDevInfo{
+				Name:     "mlx5_2",
+				Uverbs:   "/dev/infiniband/uverbs2",
+				Umad:     "/dev/infiniband/umad2",
+				PortGUID: "00:02:c9:03:00:00:00:01",
+				LID:      "0x0005",
+			}))
+		})
+		It("Assuming PCI device without RDMA device", func() {
+			_, err := GetPciRdmaDevInfo("0000:af:06.1")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming RDMA device with active ports", func() {
+			Expect(WaitForRdmaDevPortsActive("mlx5_0", true, time.Second)).To(Succeed())
+		})
+		It("Assuming RDMA device with inactive ports", func() {
+			err := WaitForRdmaDevPortsActive("mlx5_2", true, 0)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("last state: port 1 state DOWN phys_state Polling"))
+		})
+		It("Assuming RDMA device port becomes active", func() {
+			stateFile := filepath.Join(InfinibandDirectory, "mlx5_2", "ports", "1", "state")
+			DeferCleanup(func() {
+				Expect(os.WriteFile(stateFile, []byte("1: DOWN"), 0o600)).To(Succeed())
+			})
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(2 * pollInterval)
+				Expect(os.WriteFile(stateFile, []byte("4: ACTIVE"), 0o600)).To(Succeed())
+			}()
+			Expect(WaitForRdmaDevPortsActive("mlx5_2", false, 5*time.Second)).To(Succeed())
+		})
+		It("Assuming RDMA device pkey table", func() {
+			pkeys, err := GetRdmaDevPKeys("mlx5_2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pkeys).To(ConsistOf(uint16(0xffff), uint16(0x8005), uint16(0x0006)))
+
+			pkeys, err = GetRdmaDevPKeys("mlx5_0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pkeys).To(BeEmpty())
+		})
+		It("Assuming pkey in the RDMA device pkey table", func() {
+			Expect(WaitForRdmaDevPKey("mlx5_2", 0x8005, time.Second)).To(Succeed())
+		})
+		It("Assuming pkey not in the RDMA device pkey table", func() {
+			err := WaitForRdmaDevPKey("mlx5_2", 0x8007, 0)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("timed out after 0s waiting for pkey 0x8007"))
+		})
+		It("Assuming pkey added to the RDMA device pkey table", func() {
+			pkeyFile := filepath.Join(InfinibandDirectory, "mlx5_2", "ports", "1", "pkeys", "3")
+			DeferCleanup(func() {
+				Expect(os.WriteFile(pkeyFile, []byte("0x0000"), 0o600)).To(Succeed())
+			})
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(2 * pollInterval)
+				Expect(os.WriteFile(pkeyFile, []byte("0x8007"), 0o600)).To(Succeed())
+			}()
+			Expect(WaitForRdmaDevPKey("mlx5_2", 0x8007, 5*time.Second)).To(Succeed())
+		})
+		It("Assuming VF netdevice and RDMA device in current namespace", func() {
+			ifName, err := WaitForVFNetdev("0000:af:06.0", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ifName).To(Equal("ib1"))
+
+			rdmaDev, err := WaitForPciRdmaDev("0000:af:06.0", time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rdmaDev).To(Equal("mlx5_2"))
+		})
+		It("Assuming VF netdevice and RDMA device not returned", func() {
+			_, err := WaitForVFNetdev("0000:af:07.0", 200*time.Millisecond)
+			Expect(err).To(HaveOccurred())
+
+			_, err = WaitForPciRdmaDev("0000:af:06.1", 200*time.Millisecond)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking node description functions", func() {
+		It("Assuming VF with RDMA device", func() {
+			Expect(GetPciRdmaDevNodeDesc("0000:af:06.0")).To(Equal("MT4120 ConnectX-5 Mellanox Technologies"))
+			Expect(SetPciRdmaDevNodeDesc("0000:af:06.0", "default/pod-0 net1")).To(Succeed())
+			Expect(GetPciRdmaDevNodeDesc("0000:af:06.0")).To(Equal("default/pod-0 net1"))
+			Expect(SetPciRdmaDevNodeDesc("0000:af:06.0", "MT4120 ConnectX-5 Mellanox Technologies")).To(Succeed())
+		})
+		It("Assuming VF without RDMA device", func() {
+			_, err := GetPciRdmaDevNodeDesc("0000:af:06.1")
+			Expect(err).To(HaveOccurred())
+			Expect(SetPciRdmaDevNodeDesc("0000:af:06.1", "desc")).NotTo(Succeed())
+		})
+	})
+	Context("Checking GetPciRdmaDevNodeGUID function", func() {
+		It("Assuming VF with RDMA device", func() {
+			Expect(GetPciRdmaDevNodeGUID("0000:af:06.0")).To(Equal("00:02:c9:03:00:00:00:01"))
+		})
+		It("Assuming VF without RDMA device", func() {
+			_, err := GetPciRdmaDevNodeGUID("0000:af:06.1")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking IPoIB mode functions", func() {
+		It("Assuming MTU allowed by the mode", func() {
+			Expect(ValidateIPoIBMTU(IPoIBModeDatagram, 4092)).To(Succeed())
+			Expect(ValidateIPoIBMTU(IPoIBModeConnected, 65520)).To(Succeed())
+		})
+		It("Assuming MTU not allowed by the mode", func() {
+			Expect(ValidateIPoIBMTU(IPoIBModeDatagram, 9000)).NotTo(Succeed())
+			Expect(ValidateIPoIBMTU(IPoIBModeConnected, 65521)).NotTo(Succeed())
+			Expect(ValidateIPoIBMTU(IPoIBModeConnected, 60)).NotTo(Succeed())
+		})
+		It("Assuming existing IPoIB netdevice", func() {
+			Expect(GetIPoIBMode("ib0")).To(Equal(IPoIBModeDatagram))
+			Expect(SetIPoIBMode("ib0", IPoIBModeConnected)).To(Succeed())
+			Expect(GetIPoIBMode("ib0")).To(Equal(IPoIBModeConnected))
+			Expect(SetIPoIBMode("ib0", IPoIBModeDatagram)).To(Succeed())
+		})
+		It("Assuming not existing netdevice", func() {
+			_, err := GetIPoIBMode("ib9")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking LockFile function", func() {
+		It("Assuming free and held lock", func() {
+			tmpDir, err := os.MkdirTemp("", "ib-sriov-cni-lock-")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(tmpDir)
+
+			lockFile := filepath.Join(tmpDir, "locks", "test.lock")
+			lock, err := LockFile(lockFile, time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			defer func() { _ = lock.Unlock() }()
+
+			_, err = LockFile(lockFile, 2*lockRetryDelay)
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrLockTimeout)).To(BeTrue())
+		})
+	})
 })